@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Operatingsystem describes the operating system of an AMI source in the version YAML.
+type Operatingsystem struct {
+	Name         string `json:"name"`
+	Version      string `json:"version"`
+	Username     string `json:"username"`
+	Scanningport int    `json:"scanningport"`
+}
+
+// Securitygroups is an inbound rule recommended to buyers launching the AMI.
+type Securitygroups struct {
+	Protocol string   `json:"protocol"`
+	Fromport int      `json:"fromport"`
+	Toport   int      `json:"toport"`
+	Cidrips  []string `json:"cidrips"`
+}
+
+type AmiSource struct {
+	AmiID                  string `json:"AmiId"`
+	AccessRoleArn          string `json:"AccessRoleArn"`
+	UserName               string `json:"UserName"`
+	OperatingSystemName    string `json:"OperatingSystemName"`
+	OperatingSystemVersion string `json:"OperatingSystemVersion"`
+	ScanningPort           int    `json:"ScanningPort,omitempty"`
+}
+
+type SecurityGroups struct {
+	IPProtocol string   `json:"IpProtocol"`
+	FromPort   int      `json:"FromPort"`
+	ToPort     int      `json:"ToPort"`
+	IPRanges   []string `json:"IpRanges"`
+}
+
+type AmiDeliveryOptionDetails struct {
+	AmiSource               *AmiSource       `json:"AmiSource,omitempty"`
+	UsageInstructions       string           `json:"UsageInstructions"`
+	RecommendedInstanceType string           `json:"RecommendedInstanceType"`
+	SecurityGroups          []SecurityGroups `json:"SecurityGroups"`
+}
+
+func convertAmiDeliveryOption(opt Deliveryoptions, source Sources) *AmiDeliveryOptionDetails {
+	groups := make([]SecurityGroups, 0, len(opt.Recommendations.Securitygroups))
+	for _, sg := range opt.Recommendations.Securitygroups {
+		groups = append(groups, SecurityGroups{
+			IPProtocol: sg.Protocol,
+			FromPort:   sg.Fromport,
+			ToPort:     sg.Toport,
+			IPRanges:   sg.Cidrips,
+		})
+	}
+	return &AmiDeliveryOptionDetails{
+		AmiSource: &AmiSource{
+			AmiID:                  source.Image,
+			AccessRoleArn:          source.Accessrolearn,
+			UserName:               source.Operatingsystem.Username,
+			OperatingSystemName:    source.Operatingsystem.Name,
+			OperatingSystemVersion: source.Operatingsystem.Version,
+			ScanningPort:           source.Operatingsystem.Scanningport,
+		},
+		UsageInstructions:       opt.Instructions.Usage,
+		RecommendedInstanceType: opt.Recommendations.Instancetype,
+		SecurityGroups:          groups,
+	}
+}
+
+// Cloudformation is the CloudFormation template section of a delivery option in the version YAML.
+type Cloudformation struct {
+	Templateurl            string          `json:"templateurl"`
+	Architecturediagramurl string          `json:"architecturediagramurl"`
+	Amiparameters          []Amiparameters `json:"amiparameters"`
+}
+
+// Amiparameters maps a template parameter to the SSM parameter alias that resolves to the version's AMI.
+type Amiparameters struct {
+	Parametername string `json:"parametername"`
+	Amialias      string `json:"amialias"`
+}
+
+type DeploymentTemplateDeliveryOptionDetails struct {
+	ShortDescription          string                     `json:"ShortDescription"`
+	UsageInstructions         string                     `json:"UsageInstructions"`
+	RecommendedInstanceType   string                     `json:"RecommendedInstanceType,omitempty"`
+	Template                  string                     `json:"Template"`
+	ArchitectureDiagram       string                     `json:"ArchitectureDiagram"`
+	AmiAliasParameterMappings []AmiAliasParameterMapping `json:"AmiAliasParameterMappings"`
+}
+
+type AmiAliasParameterMapping struct {
+	ParameterName string `json:"ParameterName"`
+	AmiAlias      string `json:"AmiAlias"`
+	AmiID         string `json:"AmiId"`
+}
+
+func convertCloudformationDeliveryOption(opt Deliveryoptions, source Sources) *DeploymentTemplateDeliveryOptionDetails {
+	mappings := make([]AmiAliasParameterMapping, 0, len(opt.Cloudformation.Amiparameters))
+	for _, p := range opt.Cloudformation.Amiparameters {
+		mappings = append(mappings, AmiAliasParameterMapping{
+			ParameterName: p.Parametername,
+			AmiAlias:      p.Amialias,
+			AmiID:         source.Image,
+		})
+	}
+	return &DeploymentTemplateDeliveryOptionDetails{
+		ShortDescription:          opt.Shortdescription,
+		UsageInstructions:         opt.Instructions.Usage,
+		RecommendedInstanceType:   opt.Recommendations.Instancetype,
+		Template:                  opt.Cloudformation.Templateurl,
+		ArchitectureDiagram:       opt.Cloudformation.Architecturediagramurl,
+		AmiAliasParameterMappings: mappings,
+	}
+}
+
+func validateHTTPSURL(field, value string) error {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%s must be an https URL, got %q", field, value)
+	}
+	return nil
+}
+
+func validateCloudformation(opt Deliveryoptions) error {
+	cfn := opt.Cloudformation
+	if err := validateHTTPSURL("cloudformation.templateurl", cfn.Templateurl); err != nil {
+		return fmt.Errorf("CloudFormation delivery option %q: %w", opt.Title, err)
+	}
+	if err := validateHTTPSURL("cloudformation.architecturediagramurl", cfn.Architecturediagramurl); err != nil {
+		return fmt.Errorf("CloudFormation delivery option %q: %w", opt.Title, err)
+	}
+	if len(cfn.Amiparameters) == 0 {
+		return fmt.Errorf("CloudFormation delivery option %q has no cloudformation.amiparameters", opt.Title)
+	}
+	for _, p := range cfn.Amiparameters {
+		if p.Parametername == "" || p.Amialias == "" {
+			return fmt.Errorf("CloudFormation delivery option %q: every AMI parameter needs a parametername and an amialias", opt.Title)
+		}
+	}
+	return nil
+}
+
+func validateAmiID(ami string) error {
+	if !strings.HasPrefix(ami, "ami-") || len(ami) == len("ami-") {
+		return fmt.Errorf("invalid AMI ID %q, expected ami-<id>", ami)
+	}
+	return nil
+}
+
+// validateAmiSource checks the fields AWS needs to scan and publish an AMI.
+func validateAmiSource(source Sources) error {
+	if err := validateAmiID(source.Image); err != nil {
+		return fmt.Errorf("source %q: %w", source.ID, err)
+	}
+	var missing []string
+	if source.Accessrolearn == "" {
+		missing = append(missing, "accessrolearn")
+	}
+	if source.Operatingsystem.Name == "" {
+		missing = append(missing, "operatingsystem.name")
+	}
+	if source.Operatingsystem.Username == "" {
+		missing = append(missing, "operatingsystem.username")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("source %q is missing %s", source.ID, strings.Join(missing, ", "))
+	}
+	if p := source.Operatingsystem.Scanningport; p < 0 || p > 65535 {
+		return fmt.Errorf("source %q has invalid scanning port %d", source.ID, p)
+	}
+	return nil
+}
+
+// setVersionAMI sets the image of every AMI source in a version document.
+func setVersionAMI(doc *yamlDocument, ami string) error {
+	sources, _ := doc.field(doc.top(), "sources")
+	found := false
+	for _, source := range doc.items(sources) {
+		image, ok := doc.field(source, "image")
+		if !ok {
+			continue
+		}
+		found = true
+		if err := doc.setString(image, ami); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return errors.New("the version has no AMI source with an image to update")
+	}
+	return nil
+}
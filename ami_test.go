@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func amiVersionData(ami string) YAMLVersionData {
+	return YAMLVersionData{
+		Versiontitle: "v1.0",
+		Releasenotes: "notes",
+		Sources: []Sources{{
+			ID:            "src-ami",
+			Type:          "AmazonMachineImage",
+			Image:         ami,
+			Accessrolearn: "arn:aws:iam::123456789012:role/MarketplaceAmiIngestion",
+			Operatingsystem: Operatingsystem{
+				Name:         "AMAZONLINUX",
+				Version:      "2023",
+				Username:     "ec2-user",
+				Scanningport: 22,
+			},
+		}},
+		Deliveryoptions: []Deliveryoptions{{
+			Type:         "AmazonMachineImage",
+			Sourceid:     "src-ami",
+			Title:        "64-bit (x86) AMI",
+			Instructions: Instructions{Usage: "SSH as ec2-user"},
+			Recommendations: Recommendations{
+				Instancetype: "m5.large",
+				Securitygroups: []Securitygroups{
+					{Protocol: "tcp", Fromport: 22, Toport: 22, Cidrips: []string{"0.0.0.0/0"}},
+				},
+			},
+		}},
+	}
+}
+
+func TestConvertAmiDeliveryOption(t *testing.T) {
+	dst, err := amiVersionData("ami-0123456789abcdef0").convertToDst()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dst.DeliveryOptions) != 1 {
+		t.Fatalf("delivery options = %d", len(dst.DeliveryOptions))
+	}
+	opt := dst.DeliveryOptions[0]
+	if opt.DeliveryOptionTitle != "" || opt.Details.EcrDeliveryOptionDetails != nil {
+		t.Errorf("AMI option should carry only AMI details: %+v", opt)
+	}
+	ami := opt.Details.AmiDeliveryOptionDetails
+	if ami == nil {
+		t.Fatal("expected AMI details")
+	}
+	want := AmiSource{
+		AmiID:                  "ami-0123456789abcdef0",
+		AccessRoleArn:          "arn:aws:iam::123456789012:role/MarketplaceAmiIngestion",
+		UserName:               "ec2-user",
+		OperatingSystemName:    "AMAZONLINUX",
+		OperatingSystemVersion: "2023",
+		ScanningPort:           22,
+	}
+	if ami.AmiSource == nil || *ami.AmiSource != want {
+		t.Errorf("AMI source = %+v, want %+v", ami.AmiSource, want)
+	}
+	if ami.RecommendedInstanceType != "m5.large" || ami.UsageInstructions != "SSH as ec2-user" {
+		t.Errorf("AMI details = %+v", ami)
+	}
+	if len(ami.SecurityGroups) != 1 || ami.SecurityGroups[0].IPProtocol != "tcp" || ami.SecurityGroups[0].IPRanges[0] != "0.0.0.0/0" {
+		t.Errorf("security groups = %+v", ami.SecurityGroups)
+	}
+}
+
+func cloudformationOption() Deliveryoptions {
+	return Deliveryoptions{
+		Type:             "CloudFormation",
+		Sourceid:         "src-ami",
+		Title:            "CloudFormation stack",
+		Shortdescription: "Launch behind a load balancer",
+		Instructions:     Instructions{Usage: "Open the stack outputs"},
+		Cloudformation: Cloudformation{
+			Templateurl:            "https://example-bucket.s3.amazonaws.com/app.yaml",
+			Architecturediagramurl: "https://example-bucket.s3.amazonaws.com/app.png",
+			Amiparameters:          []Amiparameters{{Parametername: "AmiId", Amialias: "/aws/service/marketplace/prod-abc/1.0"}},
+		},
+	}
+}
+
+func TestConvertCloudformationDeliveryOption(t *testing.T) {
+	data := amiVersionData("ami-0123456789abcdef0")
+	data.Deliveryoptions = append(data.Deliveryoptions, cloudformationOption())
+	dst, err := data.convertToDst()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(dst.DeliveryOptions) != 2 {
+		t.Fatalf("delivery options = %d", len(dst.DeliveryOptions))
+	}
+	opt := dst.DeliveryOptions[1]
+	if opt.DeliveryOptionTitle != "CloudFormation stack" || opt.Details.AmiDeliveryOptionDetails != nil {
+		t.Errorf("CloudFormation option should keep its title and carry only template details: %+v", opt)
+	}
+	cfn := opt.Details.DeploymentTemplateDeliveryOptionDetails
+	if cfn == nil {
+		t.Fatal("expected template details")
+	}
+	if cfn.Template != "https://example-bucket.s3.amazonaws.com/app.yaml" || cfn.ArchitectureDiagram != "https://example-bucket.s3.amazonaws.com/app.png" {
+		t.Errorf("template details = %+v", cfn)
+	}
+	want := AmiAliasParameterMapping{ParameterName: "AmiId", AmiAlias: "/aws/service/marketplace/prod-abc/1.0", AmiID: "ami-0123456789abcdef0"}
+	if len(cfn.AmiAliasParameterMappings) != 1 || cfn.AmiAliasParameterMappings[0] != want {
+		t.Errorf("parameter mappings = %+v, want %+v", cfn.AmiAliasParameterMappings, want)
+	}
+}
+
+func TestValidateCloudformation(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Cloudformation)
+		wantErr string
+	}{
+		{"valid", func(*Cloudformation) {}, ""},
+		{"missing template", func(c *Cloudformation) { c.Templateurl = "" }, "templateurl must be an https URL"},
+		{"plain http diagram", func(c *Cloudformation) { c.Architecturediagramurl = "http://example.com/a.png" }, "architecturediagramurl must be an https URL"},
+		{"no parameters", func(c *Cloudformation) { c.Amiparameters = nil }, "no cloudformation.amiparameters"},
+		{"parameter without alias", func(c *Cloudformation) { c.Amiparameters[0].Amialias = "" }, "parametername and an amialias"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			opt := cloudformationOption()
+			tc.modify(&opt.Cloudformation)
+			err := validateDeliveryOption(opt)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	data := amiVersionData("")
+	data.Deliveryoptions = []Deliveryoptions{cloudformationOption()}
+	if _, err := data.convertToDst(); err == nil || !strings.Contains(err.Error(), "invalid AMI ID") {
+		t.Errorf("error = %v, want an invalid AMI ID error", err)
+	}
+}
+
+func TestValidateAmiSource(t *testing.T) {
+	valid := amiVersionData("ami-0123456789abcdef0").Sources[0]
+	tests := []struct {
+		name    string
+		modify  func(s *Sources)
+		wantErr string
+	}{
+		{"valid", func(*Sources) {}, ""},
+		{"bad AMI ID", func(s *Sources) { s.Image = "i-123" }, "invalid AMI ID"},
+		{"missing AMI ID", func(s *Sources) { s.Image = "" }, "invalid AMI ID"},
+		{"missing role and user", func(s *Sources) { s.Accessrolearn, s.Operatingsystem.Username = "", "" }, "accessrolearn, operatingsystem.username"},
+		{"bad port", func(s *Sources) { s.Operatingsystem.Scanningport = 70000 }, "scanning port"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			source := valid
+			tc.modify(&source)
+			err := validateAmiSource(source)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckDeliveryOptionTypes(t *testing.T) {
+	ami := []Deliveryoptions{{Title: "AMI", Type: "AmazonMachineImage"}}
+	ecr := []Deliveryoptions{{Title: "Images", Type: "ECR"}}
+	if err := checkDeliveryOptionTypes(productTypeServer, ami); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkDeliveryOptionTypes(productTypeServer, ecr); err == nil {
+		t.Error("server products should reject ECR options")
+	}
+	if err := checkDeliveryOptionTypes(productTypeContainer, ami); err == nil {
+		t.Error("container products should reject AMI options")
+	}
+	cfn := []Deliveryoptions{{Title: "Stack", Type: "CloudFormation"}}
+	if err := checkDeliveryOptionTypes(productTypeServer, cfn); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkDeliveryOptionTypes(productTypeContainer, cfn); err == nil {
+		t.Error("container products should reject CloudFormation options")
+	}
+}
+
+func TestSetVersionAMI(t *testing.T) {
+	src := "sources:\n- type: AmazonMachineImage\n  image: ami-0aaaaaaaaaaaaaaaa # current\n  id: src-ami\n"
+	doc, err := parseYAMLDocument([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setVersionAMI(doc, "ami-0bbbbbbbbbbbbbbbb"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := strings.Replace(src, "ami-0aaaaaaaaaaaaaaaa", "ami-0bbbbbbbbbbbbbbbb", 1)
+	if got := string(doc.bytes()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	doc, _ = parseYAMLDocument([]byte("sources:\n- images:\n  - app:1\n"))
+	if err := setVersionAMI(doc, "ami-0bbbbbbbbbbbbbbbb"); err == nil {
+		t.Error("expected error for a version without AMI sources")
+	}
+}
+
+func TestReleaseAMIVersion(t *testing.T) {
+	t.Run("publishes the new AMI from the local base version", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		base := amiVersionData("ami-0aaaaaaaaaaaaaaaa")
+		data, _ := yaml.Marshal(base)
+		dir := filepath.Join("data", "MyProduct", "versions")
+		_ = os.MkdirAll(dir, 0o755)
+		if err := os.WriteFile(filepath.Join(dir, "v1.0.yaml"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		var details EntityDetails
+		live := `{"Versions":[{"VersionTitle":"v1.0","ReleaseNotes":"notes","Sources":[{"Id":"src-ami","Image":"ami-0aaaaaaaaaaaaaaaa"}]}]}`
+		if err := json.Unmarshal([]byte(live), &details); err != nil {
+			t.Fatal(err)
+		}
+		svc := foundMock(t, "MyProduct", "eid-1", productTypeServer, &details)
+
+		err := releaseVersionWithClient(svc, "MyProduct", "v2.0", "", "New AMI", "v1.0",
+			releaseOptions{ami: "ami-0bbbbbbbbbbbbbbbb"}, changeSetOptions{noOp: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := getYAMLData(filepath.Join(dir, "v2.0.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Sources[0].Image != "ami-0bbbbbbbbbbbbbbbb" || got.Releasenotes != "New AMI" || got.Versiontitle != "v2.0" {
+			t.Errorf("new version = %+v", got)
+		}
+		if got.Sources[0].Accessrolearn == "" {
+			t.Error("local-only fields of the base version should carry over")
+		}
+	})
+
+	t.Run("publishes the new AMI from a dumped CloudFormation version", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		payload := `{"Versions":[{"Id":"vid-1","VersionTitle":"v1.0","ReleaseNotes":"notes","CreationDate":"2024-01-01T00:00:00Z",` +
+			`"Sources":[{"Type":"AmazonMachineImage","Id":"src-ami","Image":"ami-0aaaaaaaaaaaaaaaa",` +
+			`"OperatingSystem":{"Name":"AMAZONLINUX","Version":"2023","Username":"ec2-user","ScanningPort":22}}],` +
+			`"DeliveryOptions":[{"Id":"do-1","Type":"CloudFormation","SourceId":"src-ami","Title":"Stack",` +
+			`"Template":"https://example.com/stack.yaml","ArchitectureDiagram":"https://example.com/diagram.png",` +
+			`"AmiAliasParameterMappings":[{"ParameterName":"ImageId","AmiAlias":"/aws/service/marketplace/prod-1/v1.0","AmiId":"ami-0aaaaaaaaaaaaaaaa"}]}]}]}`
+		svc := payloadMock("MyProduct", "eid-1", productTypeServer, payload)
+		if err := dumpVersionsWithClient(svc, "MyProduct"); err != nil {
+			t.Fatal(err)
+		}
+		// AWS does not return the ingestion role, so it is the one setting added to the dumped version.
+		path := filepath.Join("data", "MyProduct", "versions", "v1.0.yaml")
+		dumped, _ := os.ReadFile(path)
+		image := "\n  image: ami-0aaaaaaaaaaaaaaaa\n"
+		if !strings.Contains(string(dumped), image) {
+			t.Fatalf("dumped version has no AMI source:\n%s", dumped)
+		}
+		withRole := strings.Replace(string(dumped), image, image+"  accessrolearn: arn:aws:iam::123456789012:role/MarketplaceAmiIngestion\n", 1)
+		if err := os.WriteFile(path, []byte(withRole), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := releaseVersionWithClient(svc, "MyProduct", "v2.0", "", "New AMI", "v1.0",
+			releaseOptions{ami: "ami-0bbbbbbbbbbbbbbbb"}, changeSetOptions{noOp: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, err := getYAMLData(filepath.Join("data", "MyProduct", "versions", "v2.0.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfn := got.Deliveryoptions[0].Cloudformation
+		if cfn.Templateurl != "https://example.com/stack.yaml" || len(cfn.Amiparameters) != 1 || cfn.Amiparameters[0].Parametername != "ImageId" {
+			t.Errorf("cloudformation = %+v", cfn)
+		}
+	})
+
+	t.Run("rejects mismatched product types and flags", func(t *testing.T) {
+		details := makeEntityDetailsWithVersion(t, "v1.0")
+		container := foundMock(t, "MyProduct", "eid-1", productTypeContainer, details)
+		err := releaseVersionWithClient(container, "MyProduct", "v2.0", "", "notes", "v1.0",
+			releaseOptions{ami: "ami-0bbbbbbbbbbbbbbbb"}, changeSetOptions{noOp: true})
+		if err == nil || !strings.Contains(err.Error(), "--ami can only be used") {
+			t.Errorf("error = %v", err)
+		}
+
+		server := foundMock(t, "MyProduct", "eid-1", productTypeServer, details)
+		err = releaseVersionWithClient(server, "MyProduct", "v2.0", "app:2", "notes", "v1.0", releaseOptions{}, changeSetOptions{noOp: true})
+		if err == nil || !strings.Contains(err.Error(), "--ami instead of --image") {
+			t.Errorf("error = %v", err)
+		}
+
+		err = releaseVersionWithClient(server, "MyProduct", "v2.0", "app:2", "notes", "v1.0",
+			releaseOptions{ami: "ami-0bbbbbbbbbbbbbbbb"}, changeSetOptions{noOp: true})
+		if err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+			t.Errorf("error = %v", err)
+		}
+
+		err = releaseVersionWithClient(server, "MyProduct", "v2.0", "", "notes", "v1.0",
+			releaseOptions{ami: "not-an-ami"}, changeSetOptions{noOp: true})
+		if err == nil || !strings.Contains(err.Error(), "invalid AMI ID") {
+			t.Errorf("error = %v", err)
+		}
+	})
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog/types"
+)
+
+// changeSetOptions controls how a change set is submitted and followed up on. The registry options apply to
+// the checks of the images a change set publishes.
+type changeSetOptions struct {
+	noOp         bool
+	wait         bool
+	queue        bool
+	waitTimeout  time.Duration
+	requestToken string
+	registry     registryOptions
+	result       *changeSetResult
+}
+
+// changeSetResult records the change set a command submitted, for callers reporting on several of them.
+type changeSetResult struct {
+	id     string
+	status string
+}
+
+// record stores the change set and its last known status in opts.result, when the caller asked for it.
+func (opts changeSetOptions) record(changeSetID, status string) {
+	if opts.result != nil {
+		opts.result.id, opts.result.status = changeSetID, status
+	}
+}
+
+// Polling starts at changeSetPollInterval and doubles after every attempt up to changeSetMaxPollInterval.
+var (
+	changeSetPollInterval    = 5 * time.Second
+	changeSetMaxPollInterval = time.Minute
+)
+
+// maxRequestTokenLength is the longest ClientRequestToken StartChangeSet accepts.
+const maxRequestTokenLength = 64
+
+// validateRequestToken checks an explicit --request-token against the constraints of the StartChangeSet API.
+func validateRequestToken(token string) error {
+	if len(token) > maxRequestTokenLength {
+		return fmt.Errorf("request token must be at most %d characters long", maxRequestTokenLength)
+	}
+	for _, r := range token {
+		if r < '!' || r > '~' {
+			return fmt.Errorf("request token %q must only contain printable ASCII characters without spaces", token)
+		}
+	}
+	return nil
+}
+
+// changeSetRequestToken returns the ClientRequestToken for a change set. Unless overridden, it is a hash of the
+// product, change types and Details payloads, so retrying the same content is deduplicated by the API.
+func changeSetRequestToken(productName string, changes []types.Change, override string) (string, error) {
+	if override != "" {
+		return override, validateRequestToken(override)
+	}
+	h := sha256.New()
+	h.Write([]byte(productName))
+	for i := range changes {
+		h.Write([]byte{0})
+		h.Write([]byte(aws.ToString(changes[i].ChangeType)))
+		h.Write([]byte{0})
+		h.Write([]byte(aws.ToString(changes[i].Details)))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32], nil
+}
+
+// startChangeSet submits the change set and reports the identifiers AWS assigned to it.
+func startChangeSet(svc marketplaceClient, input *marketplacecatalog.StartChangeSetInput) (string, error) {
+	resp, err := svc.StartChangeSet(context.Background(), input)
+	if err != nil {
+		return "", err
+	}
+	changeSetID := aws.ToString(resp.ChangeSetId)
+	fmt.Printf("Changeset ID: %s\n", changeSetID)
+	fmt.Printf("Changeset ARN: %s\n", aws.ToString(resp.ChangeSetArn))
+	return changeSetID, nil
+}
+
+// formatErrorDetails renders the errors AWS reported for a single change, one per line.
+func formatErrorDetails(details []types.ErrorDetail) []string {
+	lines := make([]string, 0, len(details))
+	for _, d := range details {
+		code := aws.ToString(d.ErrorCode)
+		msg := strings.TrimSpace(aws.ToString(d.ErrorMessage))
+		switch {
+		case code == "":
+			lines = append(lines, msg)
+		case msg == "":
+			lines = append(lines, code)
+		default:
+			lines = append(lines, code+": "+msg)
+		}
+	}
+	return lines
+}
+
+func printChangeSummary(change *types.ChangeSummary) {
+	target := ""
+	if change.Entity != nil {
+		target = fmt.Sprintf(" on %s %s", aws.ToString(change.Entity.Type), aws.ToString(change.Entity.Identifier))
+	}
+	fmt.Printf("  - %s (%s)%s\n", aws.ToString(change.ChangeType), aws.ToString(change.ChangeName), target)
+	for _, line := range formatErrorDetails(change.ErrorDetailList) {
+		fmt.Printf("      error: %s\n", line)
+	}
+}
+
+func printChangeSet(cs *marketplacecatalog.DescribeChangeSetOutput) {
+	fmt.Printf("Changeset ID:   %s\n", aws.ToString(cs.ChangeSetId))
+	fmt.Printf("Changeset ARN:  %s\n", aws.ToString(cs.ChangeSetArn))
+	fmt.Printf("Name:           %s\n", aws.ToString(cs.ChangeSetName))
+	fmt.Printf("Status:         %s\n", cs.Status)
+	fmt.Printf("Started:        %s\n", aws.ToString(cs.StartTime))
+	if cs.EndTime != nil {
+		fmt.Printf("Ended:          %s\n", aws.ToString(cs.EndTime))
+	}
+	if cs.FailureCode != "" {
+		fmt.Printf("Failure:        %s: %s\n", cs.FailureCode, aws.ToString(cs.FailureDescription))
+	}
+	if len(cs.ChangeSet) == 0 {
+		return
+	}
+	fmt.Println("Changes:")
+	for i := range cs.ChangeSet {
+		printChangeSummary(&cs.ChangeSet[i])
+	}
+}
+
+func describeChangeSetWithClient(svc marketplaceClient, changeSetID string) error {
+	resp, err := svc.DescribeChangeSet(context.Background(), &marketplacecatalog.DescribeChangeSetInput{
+		Catalog:     aws.String("AWSMarketplace"),
+		ChangeSetId: aws.String(changeSetID),
+	})
+	if err != nil {
+		return fmt.Errorf("could not describe change set %s: %w", changeSetID, err)
+	}
+	printChangeSet(resp)
+	return nil
+}
+
+func describeChangeSet(changeSetID string) error {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return err
+	}
+	return describeChangeSetWithClient(marketplacecatalog.NewFromConfig(cfg), changeSetID)
+}
+
+// validateChangeSetStatus accepts an empty status (no filter) or any known ChangeStatus, case-insensitively.
+func validateChangeSetStatus(status string) (string, error) {
+	if status == "" {
+		return "", nil
+	}
+	upper := strings.ToUpper(status)
+	if !slices.Contains(types.ChangeStatus("").Values(), types.ChangeStatus(upper)) {
+		valid := make([]string, 0, len(types.ChangeStatus("").Values()))
+		for _, s := range types.ChangeStatus("").Values() {
+			valid = append(valid, string(s))
+		}
+		return "", fmt.Errorf("invalid change set status: %s. Valid statuses are: %s", status, strings.Join(valid, ", "))
+	}
+	return upper, nil
+}
+
+// paginateChangeSets fetches all change set summaries for the given params, following pagination tokens.
+func paginateChangeSets(ctx context.Context, svc marketplaceClient, params *marketplacecatalog.ListChangeSetsInput) ([]types.ChangeSetSummaryListItem, error) {
+	var items []types.ChangeSetSummaryListItem
+	for {
+		resp, err := svc.ListChangeSets(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, resp.ChangeSetSummaryList...)
+		if resp.NextToken == nil {
+			return items, nil
+		}
+		params.NextToken = resp.NextToken
+	}
+}
+
+func printChangeSetList(items []types.ChangeSetSummaryListItem) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tSTATUS\tSTARTED\tENDED\tNAME")
+	for i := range items {
+		cs := &items[i]
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			aws.ToString(cs.ChangeSetId), cs.Status, aws.ToString(cs.StartTime), aws.ToString(cs.EndTime), aws.ToString(cs.ChangeSetName))
+	}
+	_ = w.Flush()
+}
+
+func listChangeSetsWithClient(svc marketplaceClient, status string) error {
+	status, err := validateChangeSetStatus(status)
+	if err != nil {
+		return err
+	}
+
+	params := &marketplacecatalog.ListChangeSetsInput{
+		Catalog: aws.String("AWSMarketplace"),
+		Sort: &types.Sort{
+			SortBy:    aws.String("StartTime"),
+			SortOrder: types.SortOrderDescending,
+		},
+	}
+	if status != "" {
+		params.FilterList = []types.Filter{{Name: aws.String("Status"), ValueList: []string{status}}}
+	}
+
+	items, err := paginateChangeSets(context.Background(), svc, params)
+	if err != nil {
+		return fmt.Errorf("could not list change sets: %w", err)
+	}
+	if len(items) == 0 {
+		fmt.Println("No change sets found")
+		return nil
+	}
+	printChangeSetList(items)
+	return nil
+}
+
+func listChangeSets(status string) error {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return err
+	}
+	return listChangeSetsWithClient(marketplacecatalog.NewFromConfig(cfg), status)
+}
+
+func isTerminalChangeSetStatus(status types.ChangeStatus) bool {
+	return status == types.ChangeStatusSucceeded || status == types.ChangeStatusFailed || status == types.ChangeStatusCancelled
+}
+
+// pollChangeSet describes the change set until it reaches a terminal state, backing off between attempts.
+// Status transitions are printed as they are observed.
+func pollChangeSet(ctx context.Context, svc marketplaceClient, changeSetID string) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+	start := time.Now()
+	interval := changeSetPollInterval
+	var lastStatus types.ChangeStatus
+	for {
+		resp, err := svc.DescribeChangeSet(ctx, &marketplacecatalog.DescribeChangeSetInput{
+			Catalog:     aws.String("AWSMarketplace"),
+			ChangeSetId: aws.String(changeSetID),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not describe change set %s: %w", changeSetID, err)
+		}
+		if resp.Status != lastStatus {
+			fmt.Printf("Changeset %s is %s (%s elapsed)\n", changeSetID, resp.Status, time.Since(start).Round(time.Second))
+			lastStatus = resp.Status
+		}
+		if isTerminalChangeSetStatus(resp.Status) {
+			return resp, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up waiting for changeset %s in status %s: %w", changeSetID, lastStatus, ctx.Err())
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, changeSetMaxPollInterval)
+	}
+}
+
+// changeSetOutcome converts a change set in a terminal state into an error unless it succeeded.
+func changeSetOutcome(changeSetID string, cs *marketplacecatalog.DescribeChangeSetOutput) error {
+	switch cs.Status {
+	case types.ChangeStatusSucceeded:
+		fmt.Printf("Changeset %s succeeded\n", changeSetID)
+		return nil
+	case types.ChangeStatusCancelled:
+		return fmt.Errorf("changeset %s was cancelled", changeSetID)
+	}
+
+	printChangeSet(cs)
+	var reasons []string
+	if cs.FailureDescription != nil {
+		reasons = append(reasons, aws.ToString(cs.FailureDescription))
+	}
+	for i := range cs.ChangeSet {
+		reasons = append(reasons, formatErrorDetails(cs.ChangeSet[i].ErrorDetailList)...)
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("changeset %s failed", changeSetID)
+	}
+	return fmt.Errorf("changeset %s failed: %s", changeSetID, strings.Join(reasons, "; "))
+}
+
+// waitContext bounds a wait by opts.waitTimeout; a non-positive timeout waits indefinitely. A submission creates
+// it once, so queueing behind another change set and waiting for the result share a single deadline.
+func waitContext(opts changeSetOptions) (context.Context, context.CancelFunc) {
+	if opts.waitTimeout > 0 {
+		return context.WithTimeout(context.Background(), opts.waitTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// awaitChangeSet blocks until the change set finishes or ctx is done when opts.wait is set, and reports whether
+// it succeeded.
+func awaitChangeSet(ctx context.Context, svc marketplaceClient, changeSetID string, opts changeSetOptions) error {
+	if !opts.wait {
+		return nil
+	}
+	cs, err := pollChangeSet(ctx, svc, changeSetID)
+	if err != nil {
+		return err
+	}
+	opts.record(changeSetID, string(cs.Status))
+	return changeSetOutcome(changeSetID, cs)
+}
+
+// pendingChangeSets returns the change sets for entityID that have not reached a terminal state yet.
+func pendingChangeSets(ctx context.Context, svc marketplaceClient, entityID string) ([]types.ChangeSetSummaryListItem, error) {
+	params := &marketplacecatalog.ListChangeSetsInput{
+		Catalog: aws.String("AWSMarketplace"),
+		FilterList: []types.Filter{
+			{Name: aws.String("EntityId"), ValueList: []string{entityID}},
+			{Name: aws.String("Status"), ValueList: []string{string(types.ChangeStatusPreparing), string(types.ChangeStatusApplying)}},
+		},
+	}
+	items, err := paginateChangeSets(ctx, svc, params)
+	if err != nil {
+		return nil, fmt.Errorf("could not list change sets for entity %s: %w", entityID, err)
+	}
+	return items, nil
+}
+
+func cancelChangeSetByID(svc marketplaceClient, changeSetID string) error {
+	_, err := svc.CancelChangeSet(context.Background(), &marketplacecatalog.CancelChangeSetInput{
+		Catalog:     aws.String("AWSMarketplace"),
+		ChangeSetId: aws.String(changeSetID),
+	})
+	if err != nil {
+		return fmt.Errorf("could not cancel change set %s: %w", changeSetID, err)
+	}
+	fmt.Printf("Cancellation requested for changeset %s\n", changeSetID)
+	return nil
+}
+
+func cancelChangeSetWithClient(svc marketplaceClient, changeSetID string, yes bool) error {
+	if !yes && !confirm(fmt.Sprintf("Cancel changeset %s?", changeSetID)) {
+		fmt.Println("Aborted")
+		return nil
+	}
+	return cancelChangeSetByID(svc, changeSetID)
+}
+
+func cancelChangeSet(changeSetID string, yes bool) error {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return err
+	}
+	return cancelChangeSetWithClient(marketplacecatalog.NewFromConfig(cfg), changeSetID, yes)
+}
+
+func cancelPendingWithClient(svc marketplaceClient, productName string, yes bool) error {
+	entityID, _, err := findProduct(svc, productName)
+	if err != nil {
+		return err
+	}
+
+	items, err := pendingChangeSets(context.Background(), svc, entityID)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		fmt.Printf("No pending changesets for product %s\n", productName)
+		return nil
+	}
+
+	fmt.Printf("Pending changesets for product %s (entity ID %s):\n", productName, entityID)
+	printChangeSetList(items)
+	if !yes && !confirm(fmt.Sprintf("Cancel %d changeset(s)?", len(items))) {
+		fmt.Println("Aborted")
+		return nil
+	}
+
+	var errs []error
+	for i := range items {
+		if err := cancelChangeSetByID(svc, aws.ToString(items[i].ChangeSetId)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func cancelPending(productName string, yes bool) error {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return err
+	}
+	return cancelPendingWithClient(marketplacecatalog.NewFromConfig(cfg), productName, yes)
+}
+
+// describeBlockingChangeSets renders the open change sets that hold the lock on an entity.
+func describeBlockingChangeSets(items []types.ChangeSetSummaryListItem) string {
+	if len(items) == 0 {
+		return "an open changeset"
+	}
+	descs := make([]string, 0, len(items))
+	for i := range items {
+		descs = append(descs, fmt.Sprintf("%s (%s, %q)", aws.ToString(items[i].ChangeSetId), items[i].Status, aws.ToString(items[i].ChangeSetName)))
+	}
+	return "open changeset " + strings.Join(descs, ", ")
+}
+
+// waitForBlockingChangeSets waits for every blocking change set to reach a terminal state.
+// When none could be found the lock is probably being released, so it just pauses before the next attempt.
+func waitForBlockingChangeSets(ctx context.Context, svc marketplaceClient, items []types.ChangeSetSummaryListItem) error {
+	if len(items) == 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for the entity to be released: %w", ctx.Err())
+		case <-time.After(changeSetPollInterval):
+			return nil
+		}
+	}
+	for i := range items {
+		changeSetID := aws.ToString(items[i].ChangeSetId)
+		fmt.Printf("Waiting for changeset %s to finish before retrying\n", changeSetID)
+		if _, err := pollChangeSet(ctx, svc, changeSetID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// submitChangeSet starts the change set for entityID. AWS allows only one open change set per entity, so when
+// another one is still in progress it either reports which change set is blocking or, with opts.queue, waits for
+// it to finish and tries again until ctx is done.
+func submitChangeSet(ctx context.Context, svc marketplaceClient, input *marketplacecatalog.StartChangeSetInput, entityID string, opts changeSetOptions) (string, error) {
+	for {
+		changeSetID, err := startChangeSet(svc, input)
+		var inUse *types.ResourceInUseException
+		if !errors.As(err, &inUse) {
+			return changeSetID, err
+		}
+
+		blocking, listErr := pendingChangeSets(ctx, svc, entityID)
+		if listErr != nil {
+			return "", errors.Join(err, listErr)
+		}
+		if !opts.queue {
+			return "", fmt.Errorf("entity %s is locked by %s, retry once it finishes or use --queue: %w",
+				entityID, describeBlockingChangeSets(blocking), err)
+		}
+		fmt.Printf("Entity %s is locked by %s\n", entityID, describeBlockingChangeSets(blocking))
+		if err := waitForBlockingChangeSets(ctx, svc, blocking); err != nil {
+			return "", err
+		}
+	}
+}
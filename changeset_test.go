@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog/types"
+)
+
+func TestStartChangeSet(t *testing.T) {
+	t.Run("returns change set ID", func(t *testing.T) {
+		svc := &mockMarketplaceClient{
+			startChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+				return &marketplacecatalog.StartChangeSetOutput{
+					ChangeSetId:  aws.String("cs-123"),
+					ChangeSetArn: aws.String("arn:aws:aws-marketplace:us-east-1:123456789012:AWSMarketplace/ChangeSet/cs-123"),
+				}, nil
+			},
+		}
+		id, err := startChangeSet(svc, &marketplacecatalog.StartChangeSetInput{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if id != "cs-123" {
+			t.Errorf("id = %q, want cs-123", id)
+		}
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		svc := &mockMarketplaceClient{
+			startChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+				return nil, errors.New("boom")
+			},
+		}
+		if _, err := startChangeSet(svc, &marketplacecatalog.StartChangeSetInput{}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestFormatErrorDetails(t *testing.T) {
+	got := formatErrorDetails([]types.ErrorDetail{
+		{ErrorCode: aws.String("INVALID_INPUT"), ErrorMessage: aws.String("ReleaseNotes is required ")},
+		{ErrorCode: aws.String("INTERNAL_ERROR")},
+		{ErrorMessage: aws.String("something went wrong")},
+	})
+	want := []string{
+		"INVALID_INPUT: ReleaseNotes is required",
+		"INTERNAL_ERROR",
+		"something went wrong",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDescribeChangeSetWithClient(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		var gotID string
+		svc := &mockMarketplaceClient{
+			describeChangeSetFunc: func(_ context.Context, params *marketplacecatalog.DescribeChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+				gotID = *params.ChangeSetId
+				return &marketplacecatalog.DescribeChangeSetOutput{
+					ChangeSetId: aws.String("cs-1"),
+					Status:      types.ChangeStatusFailed,
+					FailureCode: types.FailureCodeClientError,
+					EndTime:     aws.String("2024-01-01T00:10:00Z"),
+					ChangeSet: []types.ChangeSummary{
+						{
+							ChangeType: aws.String("AddDeliveryOptions"),
+							ChangeName: aws.String("AddNewVersion"),
+							Entity:     &types.Entity{Type: aws.String("ContainerProduct@1.0"), Identifier: aws.String("eid-1")},
+							ErrorDetailList: []types.ErrorDetail{
+								{ErrorCode: aws.String("INVALID_INPUT"), ErrorMessage: aws.String("bad image")},
+							},
+						},
+					},
+				}, nil
+			},
+		}
+		if err := describeChangeSetWithClient(svc, "cs-1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if gotID != "cs-1" {
+			t.Errorf("ChangeSetId = %q, want cs-1", gotID)
+		}
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		svc := &mockMarketplaceClient{
+			describeChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.DescribeChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+				return nil, &types.ResourceNotFoundException{Message: aws.String("not found")}
+			},
+		}
+		err := describeChangeSetWithClient(svc, "cs-missing")
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "cs-missing") {
+			t.Errorf("error %q does not mention change set ID", err.Error())
+		}
+	})
+}
+
+func TestValidateChangeSetStatus(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"SUCCEEDED", "SUCCEEDED", false},
+		{"applying", "APPLYING", false},
+		{"DONE", "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := validateChangeSetStatus(tc.input)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestListChangeSetsWithClient(t *testing.T) {
+	t.Run("paginates and applies status filter", func(t *testing.T) {
+		call := 0
+		token := "next"
+		var gotFilters []types.Filter
+		svc := &mockMarketplaceClient{
+			listChangeSetsFunc: func(_ context.Context, params *marketplacecatalog.ListChangeSetsInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListChangeSetsOutput, error) {
+				call++
+				gotFilters = params.FilterList
+				if call == 1 {
+					return &marketplacecatalog.ListChangeSetsOutput{
+						ChangeSetSummaryList: []types.ChangeSetSummaryListItem{{ChangeSetId: aws.String("cs-1"), Status: types.ChangeStatusApplying}},
+						NextToken:            &token,
+					}, nil
+				}
+				return &marketplacecatalog.ListChangeSetsOutput{
+					ChangeSetSummaryList: []types.ChangeSetSummaryListItem{{ChangeSetId: aws.String("cs-2"), Status: types.ChangeStatusApplying}},
+				}, nil
+			},
+		}
+		if err := listChangeSetsWithClient(svc, "applying"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if call != 2 {
+			t.Errorf("ListChangeSets called %d times, want 2", call)
+		}
+		if len(gotFilters) != 1 || *gotFilters[0].Name != "Status" || gotFilters[0].ValueList[0] != "APPLYING" {
+			t.Errorf("filters = %+v", gotFilters)
+		}
+	})
+
+	t.Run("no change sets found", func(t *testing.T) {
+		svc := &mockMarketplaceClient{
+			listChangeSetsFunc: func(_ context.Context, params *marketplacecatalog.ListChangeSetsInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListChangeSetsOutput, error) {
+				if params.FilterList != nil {
+					t.Errorf("unexpected filter: %+v", params.FilterList)
+				}
+				return &marketplacecatalog.ListChangeSetsOutput{}, nil
+			},
+		}
+		if err := listChangeSetsWithClient(svc, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("invalid status returns error", func(t *testing.T) {
+		if err := listChangeSetsWithClient(&mockMarketplaceClient{}, "bogus"); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		svc := &mockMarketplaceClient{
+			listChangeSetsFunc: func(_ context.Context, _ *marketplacecatalog.ListChangeSetsInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListChangeSetsOutput, error) {
+				return nil, errors.New("list failed")
+			},
+		}
+		if err := listChangeSetsWithClient(svc, ""); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+// fastPolling shortens the change set polling intervals for the duration of the test.
+func fastPolling(t *testing.T) {
+	t.Helper()
+	origInterval, origMax := changeSetPollInterval, changeSetMaxPollInterval
+	changeSetPollInterval, changeSetMaxPollInterval = time.Millisecond, 2*time.Millisecond
+	t.Cleanup(func() {
+		changeSetPollInterval, changeSetMaxPollInterval = origInterval, origMax
+	})
+}
+
+// statusSequenceMock returns a mock whose DescribeChangeSet walks through statuses, repeating the last one.
+func statusSequenceMock(statuses ...types.ChangeStatus) (*mockMarketplaceClient, *int) {
+	calls := 0
+	svc := &mockMarketplaceClient{
+		describeChangeSetFunc: func(_ context.Context, params *marketplacecatalog.DescribeChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+			status := statuses[min(calls, len(statuses)-1)]
+			calls++
+			return &marketplacecatalog.DescribeChangeSetOutput{ChangeSetId: params.ChangeSetId, Status: status}, nil
+		},
+	}
+	return svc, &calls
+}
+
+func TestAwaitChangeSet(t *testing.T) {
+	t.Run("does nothing without wait", func(t *testing.T) {
+		if err := awaitChangeSet(context.Background(), &mockMarketplaceClient{}, "cs-1", changeSetOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("polls until succeeded", func(t *testing.T) {
+		fastPolling(t)
+		svc, calls := statusSequenceMock(types.ChangeStatusPreparing, types.ChangeStatusApplying, types.ChangeStatusSucceeded)
+		if err := awaitChangeSet(context.Background(), svc, "cs-1", changeSetOptions{wait: true}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if *calls != 3 {
+			t.Errorf("DescribeChangeSet called %d times, want 3", *calls)
+		}
+	})
+
+	t.Run("failed change set returns error details", func(t *testing.T) {
+		fastPolling(t)
+		svc := &mockMarketplaceClient{
+			describeChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.DescribeChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+				return &marketplacecatalog.DescribeChangeSetOutput{
+					Status:             types.ChangeStatusFailed,
+					FailureCode:        types.FailureCodeClientError,
+					FailureDescription: aws.String("One or more changes failed"),
+					ChangeSet: []types.ChangeSummary{{
+						ErrorDetailList: []types.ErrorDetail{{ErrorCode: aws.String("INVALID_INPUT"), ErrorMessage: aws.String("image not found")}},
+					}},
+				}, nil
+			},
+		}
+		err := awaitChangeSet(context.Background(), svc, "cs-1", changeSetOptions{wait: true})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "INVALID_INPUT: image not found") {
+			t.Errorf("error %q does not include error details", err.Error())
+		}
+	})
+
+	t.Run("cancelled change set returns error", func(t *testing.T) {
+		fastPolling(t)
+		svc, _ := statusSequenceMock(types.ChangeStatusCancelled)
+		if err := awaitChangeSet(context.Background(), svc, "cs-1", changeSetOptions{wait: true}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		fastPolling(t)
+		svc, _ := statusSequenceMock(types.ChangeStatusApplying)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := awaitChangeSet(ctx, svc, "cs-1", changeSetOptions{wait: true})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error = %v, want deadline exceeded", err)
+		}
+	})
+
+	t.Run("describe error returned", func(t *testing.T) {
+		svc := &mockMarketplaceClient{
+			describeChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.DescribeChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+				return nil, errors.New("throttled")
+			},
+		}
+		if err := awaitChangeSet(context.Background(), svc, "cs-1", changeSetOptions{wait: true}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestCancelChangeSetWithClient(t *testing.T) {
+	t.Run("cancels after confirmation", func(t *testing.T) {
+		withConfirmInput(t, "y\n")
+		var gotID string
+		svc := &mockMarketplaceClient{
+			cancelChangeSetFunc: func(_ context.Context, params *marketplacecatalog.CancelChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.CancelChangeSetOutput, error) {
+				gotID = *params.ChangeSetId
+				return &marketplacecatalog.CancelChangeSetOutput{}, nil
+			},
+		}
+		if err := cancelChangeSetWithClient(svc, "cs-1", false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if gotID != "cs-1" {
+			t.Errorf("cancelled %q, want cs-1", gotID)
+		}
+	})
+
+	t.Run("declined confirmation does not cancel", func(t *testing.T) {
+		withConfirmInput(t, "n\n")
+		svc := &mockMarketplaceClient{
+			cancelChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.CancelChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.CancelChangeSetOutput, error) {
+				t.Error("CancelChangeSet should not be called")
+				return &marketplacecatalog.CancelChangeSetOutput{}, nil
+			},
+		}
+		if err := cancelChangeSetWithClient(svc, "cs-1", false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		svc := &mockMarketplaceClient{
+			cancelChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.CancelChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.CancelChangeSetOutput, error) {
+				return nil, &types.ResourceInUseException{Message: aws.String("already applying")}
+			},
+		}
+		if err := cancelChangeSetWithClient(svc, "cs-1", true); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestCancelPendingWithClient(t *testing.T) {
+	pendingMock := func(t *testing.T, ids ...string) (*mockMarketplaceClient, *[]string) {
+		t.Helper()
+		svc := foundMock(t, testProductName, "eid-1", productTypeContainer, &EntityDetails{})
+		var cancelled []string
+		svc.listChangeSetsFunc = func(_ context.Context, params *marketplacecatalog.ListChangeSetsInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListChangeSetsOutput, error) {
+			if len(params.FilterList) != 2 || params.FilterList[0].ValueList[0] != "eid-1" {
+				t.Errorf("filters = %+v", params.FilterList)
+			}
+			out := &marketplacecatalog.ListChangeSetsOutput{}
+			for _, id := range ids {
+				out.ChangeSetSummaryList = append(out.ChangeSetSummaryList, types.ChangeSetSummaryListItem{ChangeSetId: aws.String(id), Status: types.ChangeStatusPreparing})
+			}
+			return out, nil
+		}
+		svc.cancelChangeSetFunc = func(_ context.Context, params *marketplacecatalog.CancelChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.CancelChangeSetOutput, error) {
+			cancelled = append(cancelled, *params.ChangeSetId)
+			return &marketplacecatalog.CancelChangeSetOutput{}, nil
+		}
+		return svc, &cancelled
+	}
+
+	t.Run("cancels every pending change set with --yes", func(t *testing.T) {
+		svc, cancelled := pendingMock(t, "cs-1", "cs-2")
+		if err := cancelPendingWithClient(svc, testProductName, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if strings.Join(*cancelled, ",") != "cs-1,cs-2" {
+			t.Errorf("cancelled = %v", *cancelled)
+		}
+	})
+
+	t.Run("declined confirmation cancels nothing", func(t *testing.T) {
+		withConfirmInput(t, "\n")
+		svc, cancelled := pendingMock(t, "cs-1")
+		if err := cancelPendingWithClient(svc, testProductName, false); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(*cancelled) != 0 {
+			t.Errorf("cancelled = %v, want none", *cancelled)
+		}
+	})
+
+	t.Run("nothing pending", func(t *testing.T) {
+		svc, cancelled := pendingMock(t)
+		if err := cancelPendingWithClient(svc, testProductName, true); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(*cancelled) != 0 {
+			t.Errorf("cancelled = %v, want none", *cancelled)
+		}
+	})
+
+	t.Run("cancel errors are collected", func(t *testing.T) {
+		svc, _ := pendingMock(t, "cs-1", "cs-2")
+		svc.cancelChangeSetFunc = func(_ context.Context, params *marketplacecatalog.CancelChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.CancelChangeSetOutput, error) {
+			return nil, errors.New("cannot cancel " + *params.ChangeSetId)
+		}
+		err := cancelPendingWithClient(svc, testProductName, true)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "cs-1") || !strings.Contains(err.Error(), "cs-2") {
+			t.Errorf("error %q should mention both change sets", err.Error())
+		}
+	})
+
+	t.Run("findProduct error propagated", func(t *testing.T) {
+		svc := &mockMarketplaceClient{
+			listEntitiesFunc: func(_ context.Context, _ *marketplacecatalog.ListEntitiesInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListEntitiesOutput, error) {
+				return &marketplacecatalog.ListEntitiesOutput{}, nil
+			},
+		}
+		if err := cancelPendingWithClient(svc, "NonExistent", true); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestSubmitChangeSet(t *testing.T) {
+	// lockedMock rejects the first StartChangeSet with ResourceInUseException while cs-busy is applying.
+	lockedMock := func(t *testing.T) (*mockMarketplaceClient, *int) {
+		t.Helper()
+		starts := 0
+		svc := &mockMarketplaceClient{
+			startChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+				starts++
+				if starts == 1 {
+					return nil, &types.ResourceInUseException{Message: aws.String("Requested change set has entities locked by change sets - cs-busy")}
+				}
+				return &marketplacecatalog.StartChangeSetOutput{ChangeSetId: aws.String("cs-new")}, nil
+			},
+			listChangeSetsFunc: func(_ context.Context, _ *marketplacecatalog.ListChangeSetsInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListChangeSetsOutput, error) {
+				return &marketplacecatalog.ListChangeSetsOutput{
+					ChangeSetSummaryList: []types.ChangeSetSummaryListItem{
+						{ChangeSetId: aws.String("cs-busy"), Status: types.ChangeStatusApplying, ChangeSetName: aws.String("Push MyProduct version 1.2")},
+					},
+				}, nil
+			},
+			describeChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.DescribeChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+				return &marketplacecatalog.DescribeChangeSetOutput{Status: types.ChangeStatusSucceeded}, nil
+			},
+		}
+		return svc, &starts
+	}
+
+	t.Run("reports blocking change set without queue", func(t *testing.T) {
+		svc, starts := lockedMock(t)
+		_, err := submitChangeSet(context.Background(), svc, &marketplacecatalog.StartChangeSetInput{}, "eid-1", changeSetOptions{})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if !strings.Contains(err.Error(), "cs-busy") {
+			t.Errorf("error %q does not name the blocking change set", err.Error())
+		}
+		var inUse *types.ResourceInUseException
+		if !errors.As(err, &inUse) {
+			t.Errorf("error %v should wrap ResourceInUseException", err)
+		}
+		if *starts != 1 {
+			t.Errorf("StartChangeSet called %d times, want 1", *starts)
+		}
+	})
+
+	t.Run("queue waits for blocking change set and retries", func(t *testing.T) {
+		fastPolling(t)
+		svc, starts := lockedMock(t)
+		id, err := submitChangeSet(context.Background(), svc, &marketplacecatalog.StartChangeSetInput{}, "eid-1", changeSetOptions{queue: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if id != "cs-new" {
+			t.Errorf("id = %q, want cs-new", id)
+		}
+		if *starts != 2 {
+			t.Errorf("StartChangeSet called %d times, want 2", *starts)
+		}
+	})
+
+	t.Run("queue gives up after timeout", func(t *testing.T) {
+		fastPolling(t)
+		svc, _ := lockedMock(t)
+		svc.describeChangeSetFunc = func(_ context.Context, _ *marketplacecatalog.DescribeChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+			return &marketplacecatalog.DescribeChangeSetOutput{Status: types.ChangeStatusApplying}, nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := submitChangeSet(ctx, svc, &marketplacecatalog.StartChangeSetInput{}, "eid-1", changeSetOptions{queue: true})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("error = %v, want deadline exceeded", err)
+		}
+	})
+
+	t.Run("queue and wait share one deadline", func(t *testing.T) {
+		fastPolling(t)
+		svc, _ := lockedMock(t)
+		svc.listEntitiesFunc = func(_ context.Context, _ *marketplacecatalog.ListEntitiesInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListEntitiesOutput, error) {
+			return makeListOutput("MyProduct", "eid-1"), nil
+		}
+		var deadlines []time.Time
+		svc.describeChangeSetFunc = func(ctx context.Context, params *marketplacecatalog.DescribeChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+			deadline, _ := ctx.Deadline()
+			deadlines = append(deadlines, deadline)
+			return &marketplacecatalog.DescribeChangeSetOutput{ChangeSetId: params.ChangeSetId, Status: types.ChangeStatusSucceeded}, nil
+		}
+		opts := changeSetOptions{queue: true, wait: true, waitTimeout: time.Minute}
+		if err := submitVersionChange(svc, "MyProduct", "eid-1", productTypeContainer, versionChange{details: map[string]string{}}, opts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(deadlines) != 2 || deadlines[0].IsZero() || !deadlines[0].Equal(deadlines[1]) {
+			t.Errorf("deadlines of the queued and awaited change sets = %v, want one shared deadline", deadlines)
+		}
+	})
+
+	t.Run("other errors returned as-is", func(t *testing.T) {
+		svc := &mockMarketplaceClient{
+			startChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+				return nil, errors.New("access denied")
+			},
+		}
+		if _, err := submitChangeSet(context.Background(), svc, &marketplacecatalog.StartChangeSetInput{}, "eid-1", changeSetOptions{queue: true}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestChangeSetRequestToken(t *testing.T) {
+	changes := func(details string) []types.Change {
+		return []types.Change{{ChangeType: aws.String("AddDeliveryOptions"), Details: aws.String(details)}}
+	}
+
+	first, err := changeSetRequestToken(testProductName, changes(`{"a":1}`), "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second, _ := changeSetRequestToken(testProductName, changes(`{"a":1}`), "")
+	if first != second {
+		t.Errorf("same content produced different tokens %q and %q", first, second)
+	}
+	if err := validateRequestToken(first); err != nil {
+		t.Errorf("generated token %q is invalid: %v", first, err)
+	}
+
+	other, _ := changeSetRequestToken(testProductName, changes(`{"a":2}`), "")
+	if other == first {
+		t.Error("different details produced the same token")
+	}
+	otherProduct, _ := changeSetRequestToken("OtherProduct", changes(`{"a":1}`), "")
+	if otherProduct == first {
+		t.Error("different product produced the same token")
+	}
+
+	override, err := changeSetRequestToken(testProductName, changes(`{"a":1}`), "ci-run-42")
+	if err != nil || override != "ci-run-42" {
+		t.Errorf("override = %q, %v", override, err)
+	}
+	if _, err := changeSetRequestToken(testProductName, nil, "has space"); err == nil {
+		t.Error("expected error for token with spaces")
+	}
+	if _, err := changeSetRequestToken(testProductName, nil, strings.Repeat("x", maxRequestTokenLength+1)); err == nil {
+		t.Error("expected error for overlong token")
+	}
+}
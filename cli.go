@@ -1,26 +1,79 @@
 package main
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
-// loadReleaseNotes resolves release notes from either the inline string or a file path.
-func loadReleaseNotes(notes, notesFile string) (string, error) {
-	if notesFile != "" {
-		data, err := os.ReadFile(notesFile) //nolint:gosec // G304: notesFile is a CLI flag value provided by the operator, intentional file read
+// loadReleaseNotes resolves release notes from the inline string, a file, the changelog section of version, or
+// the git history of a repository.
+func loadReleaseNotes(opts releaseNotesOptions, version string) (string, error) {
+	if err := checkReleaseNotesSources(opts); err != nil {
+		return "", err
+	}
+	if notes, ok, err := generatedReleaseNotes(opts, version); ok {
+		return notes, err
+	}
+	if opts.file != "" {
+		data, err := os.ReadFile(opts.file) //nolint:gosec // G304: the notes file is a CLI flag value provided by the operator, intentional file read
 		if err != nil {
 			return "", fmt.Errorf("failed to read release notes file: %w", err)
 		}
 		return string(data), nil
 	}
-	if notes == "" {
-		return "", errors.New("--release-notes or --release-notes-file is required")
+	if opts.text == "" {
+		return "", errors.New("--release-notes, --release-notes-file, --release-notes-from-changelog or --release-notes-from-git is required")
+	}
+	return opts.text, nil
+}
+
+// checkReleaseNotesSources rejects generated release notes combined with any other source. A notes file still
+// takes precedence over inline notes.
+func checkReleaseNotesSources(opts releaseNotesOptions) error {
+	if opts.gitSince != "" && opts.gitRepo == "" {
+		return errors.New("--since can only be used with --release-notes-from-git")
+	}
+	sources := 0
+	for _, set := range []bool{opts.text != "" || opts.file != "", opts.changelog != "", opts.gitRepo != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return errors.New("pass release notes either inline or from a file, a changelog or git history, not several")
 	}
-	return notes, nil
+	return nil
+}
+
+// exitCodeError makes the process exit with a specific status code. An empty message prints nothing.
+type exitCodeError struct {
+	code int
+	msg  string
+}
+
+func (e *exitCodeError) Error() string {
+	return e.msg
+}
+
+// confirmInput is where interactive confirmations are read from.
+var confirmInput io.Reader = os.Stdin
+
+// confirm asks a yes/no question on stdout and reports whether the operator answered yes.
+func confirm(prompt string) bool {
+	fmt.Printf("%s [y/N]: ", prompt)
+	answer, err := bufio.NewReader(confirmInput).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
 
 func dumpVersionsCmd() *cobra.Command {
@@ -35,17 +88,78 @@ func dumpVersionsCmd() *cobra.Command {
 	return cmd
 }
 
+// addChangeSetFlags registers the flags shared by every command that submits a change set.
+func addChangeSetFlags(cmd *cobra.Command, opts *changeSetOptions) {
+	cmd.Flags().BoolVar(&opts.noOp, "no-op", false, "Print the changeset JSON to stdout without creating the changeset")
+	cmd.Flags().BoolVar(&opts.wait, "wait", false, "Wait for the changeset to reach SUCCEEDED, FAILED or CANCELLED, failing unless it succeeds")
+	cmd.Flags().BoolVar(&opts.queue, "queue", false, "If another changeset is still open on the product, wait for it to finish and retry")
+	cmd.Flags().StringVar(&opts.requestToken, "request-token", "", "ClientRequestToken for the changeset (defaults to a hash of its content, so retries are deduplicated)")
+	cmd.Flags().DurationVar(&opts.waitTimeout, "wait-timeout", 30*time.Minute, "Maximum time to wait for a blocking changeset with --queue and for the changeset with --wait, together")
+}
+
+func addRegistryFlags(cmd *cobra.Command, opts *registryOptions) {
+	cmd.Flags().BoolVar(&opts.checkPlatforms, "check-platforms", false, "Refuse to push unless the images of every source include each platform the source declares")
+	cmd.Flags().StringVar(&opts.username, "registry-username", "", "Username for registries that require authentication")
+	cmd.Flags().StringVar(&opts.password, "registry-password", "", "Password for --registry-username")
+	cmd.Flags().StringVar(&opts.token, "registry-token", "", "Bearer token sent to the registry instead of a username and password")
+	cmd.Flags().BoolVar(&opts.insecure, "insecure-registry", false, "Talk to the registry over plain HTTP, for local test registries")
+}
+
 func addVersionCmd() *cobra.Command {
-	var noOp bool
+	var opts changeSetOptions
 	cmd := &cobra.Command{
 		Use:   "push-version [product] [version]",
 		Short: "Push local state of the product version's YAML file into a new version",
 		Args:  cobra.ExactArgs(2),
 		RunE: func(_ *cobra.Command, args []string) error {
-			return pushNewVersion(args[0], noOp, args[1])
+			return pushNewVersion(args[0], args[1], opts)
+		},
+	}
+	addRegistryFlags(cmd, &opts.registry)
+	addChangeSetFlags(cmd, &opts)
+	return cmd
+}
+
+func listVersionsCmd() *cobra.Command {
+	var order, output string
+	cmd := &cobra.Command{
+		Use:   "versions [product]",
+		Short: "List the live versions of a product with their visibility, delivery option types and images",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return listVersions(args[0], order, output)
+		},
+	}
+	cmd.Flags().StringVar(&order, "sort", "date", "Sort newest first by creation date or by semantic version: date or semver")
+	cmd.Flags().StringVar(&output, "output", "table", "Output format: table, json or yaml")
+	return cmd
+}
+
+func updateVersionCmd() *cobra.Command {
+	var opts changeSetOptions
+	cmd := &cobra.Command{
+		Use:   "update-version [product] [version]",
+		Short: "Update the delivery options of a published version whose local YAML file has changed",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return updateVersion(args[0], args[1], opts)
 		},
 	}
-	cmd.Flags().BoolVar(&noOp, "no-op", false, "Print the changeset JSON to stdout without creating the changeset")
+	addChangeSetFlags(cmd, &opts)
+	return cmd
+}
+
+func restrictVersionCmd() *cobra.Command {
+	var opts changeSetOptions
+	cmd := &cobra.Command{
+		Use:   "restrict-version [product] [version...]",
+		Short: "Restrict the delivery options of published versions so new buyers can no longer launch them",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return restrictVersions(args[0], args[1:], opts)
+		},
+	}
+	addChangeSetFlags(cmd, &opts)
 	return cmd
 }
 
@@ -84,56 +198,231 @@ Valid product types are:
 }
 
 func updateProductCmd() *cobra.Command {
-	var noOp bool
+	var opts changeSetOptions
 	cmd := &cobra.Command{
 		Use:   "update [product]",
 		Short: "Update a product's information based on the data provided in its local YAML representation",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(_ *cobra.Command, args []string) error {
-			return updateProduct(args[0], noOp)
+			return updateProduct(args[0], opts)
 		},
 	}
-	cmd.Flags().BoolVar(&noOp, "no-op", false, "Print the changeset JSON to stdout without creating the changeset")
+	addChangeSetFlags(cmd, &opts)
 	return cmd
 }
 
 func cloneProductCmd() *cobra.Command {
+	var retagImages bool
 	cmd := &cobra.Command{
 		Use:   "clone [product] [src-version] [dst-version]",
 		Short: "Copy the YAML data from the src version to the dst version",
 		Args:  cobra.ExactArgs(3),
 		RunE: func(_ *cobra.Command, args []string) error {
-			return cloneProductVersion(args[0], args[1], args[2])
+			return cloneProductVersion(args[0], args[1], args[2], retagImages)
 		},
 	}
+	cmd.Flags().BoolVar(&retagImages, "retag-images", false, "Replace the src version with the dst version in the image tags")
 	return cmd
 }
 
 func releaseCmd() *cobra.Command {
-	var noOp bool
-	var image, releaseNotes, releaseNotesFile, baseVersion string
+	var opts changeSetOptions
+	var release releaseOptions
+	var notes releaseNotesOptions
+	var images []string
+	var baseVersion string
+	var manifest string
 
 	cmd := &cobra.Command{
 		Use:   "release [product] [new-version]",
-		Short: "Automated release: clone latest version, update image and release notes, push new version",
-		Args:  cobra.ExactArgs(2),
-		RunE: func(_ *cobra.Command, args []string) error {
-			if image == "" {
-				return errors.New("--image is required")
+		Short: "Automated release: clone latest version, update image or AMI and release notes, push new version",
+		Args:  cobra.RangeArgs(0, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if manifest != "" {
+				if err := checkManifestRelease(cmd, args); err != nil {
+					return err
+				}
+				return releaseManifestFile(manifest, release, opts)
+			}
+			if len(args) == 0 {
+				return errors.New("[product] or --manifest is required")
+			}
+			image := ""
+			if len(images) == 1 && !strings.Contains(images[0], "=") {
+				image = images[0]
+			} else {
+				release.images = images
+			}
+			if image == "" && release.ami == "" && !release.mapsImages() {
+				return errors.New("--image, --images-file or --ami is required")
+			}
+			newVersion := ""
+			if len(args) == 2 {
+				newVersion = args[1]
+			} else if release.bump == "" {
+				return errors.New("[new-version] or --bump is required")
+			}
+			if err := checkReleaseNotesSources(notes); err != nil {
+				return err
 			}
-			notes, err := loadReleaseNotes(releaseNotes, releaseNotesFile)
+			if notes.source() == "" {
+				return errors.New("--release-notes, --release-notes-file, --release-notes-from-changelog or --release-notes-from-git is required")
+			}
+			release.notes = notes
+			return releaseVersion(args[0], newVersion, image, "", baseVersion, release, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&manifest, "manifest", "", "Release every product listed in this release manifest YAML file")
+	cmd.Flags().StringArrayVar(&images, "image", nil, "Image URI replacing every image of the version, or name=uri replacing the images of repository name (repeatable)")
+	cmd.Flags().StringVar(&release.imagesFile, "images-file", "", "YAML file mapping repository names to the new image URIs")
+	cmd.Flags().StringVar(&release.ami, "ami", "", "AMI ID to publish as the new version of a server product")
+	cmd.Flags().StringVar(&notes.text, "release-notes", "", "Release notes text")
+	cmd.Flags().StringVar(&notes.file, "release-notes-file", "", "Path to file containing release notes")
+	cmd.Flags().StringVar(&notes.changelog, "release-notes-from-changelog", "", "Take the release notes from the section of this Keep a Changelog file for the new version, or its Unreleased section")
+	cmd.Flags().StringVar(&notes.gitRepo, "release-notes-from-git", "", "Build the release notes from the conventional commits of this git repository")
+	cmd.Flags().StringVar(&notes.gitSince, "since", "", "Tag after which commits are included with --release-notes-from-git (defaults to the latest tag)")
+	cmd.Flags().StringVar(&baseVersion, "base-version", "", "Base version to clone from (defaults to the highest semantic version)")
+	cmd.Flags().StringVar(&release.baseConstraint, "base-constraint", "", `Only pick a base version matching this constraint, such as "~2.3" or ">=2.1 <3"`)
+	cmd.Flags().StringVar(&release.channel, "channel", "", "Pick the base version among prereleases of this channel, such as beta")
+	cmd.Flags().StringVar(&release.bump, "bump", "", "Compute the new version from the latest remote version: major, minor, patch or prerelease")
+	cmd.Flags().StringVar(&release.addOnVersion, "addon-version", "", "New version of the EKS add-on delivery options")
+	cmd.Flags().BoolVar(&opts.registry.pinDigests, "pin-digests", false, "Resolve every image tag to its digest in the registry and publish repo@sha256:... instead")
+	addRegistryFlags(cmd, &opts.registry)
+	addChangeSetFlags(cmd, &opts)
+	return cmd
+}
+
+// manifestReleaseFlags are the release flags a release manifest sets per product instead, under the flag name
+// without dashes.
+var manifestReleaseFlags = []string{
+	"image", "images-file", "ami", "release-notes", "release-notes-file", "release-notes-from-changelog",
+	"release-notes-from-git", "since", "base-version", "bump", "addon-version",
+}
+
+// checkManifestRelease rejects a product or per-product settings on the command line of a manifest release.
+func checkManifestRelease(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return errors.New("--manifest cannot be combined with a product or version, list them in the manifest")
+	}
+	for _, name := range manifestReleaseFlags {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--%s cannot be combined with --manifest, set %s per product in the manifest", name, strings.ReplaceAll(name, "-", ""))
+		}
+	}
+	return nil
+}
+
+func changeSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "changeset",
+		Short: "Inspect AWS Marketplace change sets",
+	}
+	cmd.AddCommand(describeChangeSetCmd(), listChangeSetsCmd(), cancelChangeSetCmd())
+	return cmd
+}
+
+func describeChangeSetCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "describe [changeset-id]",
+		Short: "Show the status of a change set and the errors reported for each of its changes",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return describeChangeSet(args[0])
+		},
+	}
+	return cmd
+}
+
+func listChangeSetsCmd() *cobra.Command {
+	var status string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List change sets, most recent first",
+		Args:  cobra.NoArgs,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return listChangeSets(status)
+		},
+	}
+	cmd.Flags().StringVar(&status, "status", "", "Only list change sets in this status (PREPARING, APPLYING, SUCCEEDED, CANCELLED, FAILED)")
+	return cmd
+}
+
+func cancelChangeSetCmd() *cobra.Command {
+	var yes bool
+	cmd := &cobra.Command{
+		Use:   "cancel [changeset-id]",
+		Short: "Cancel a change set that is still in progress",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return cancelChangeSet(args[0], yes)
+		},
+	}
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt")
+	return cmd
+}
+
+func cancelPendingCmd() *cobra.Command {
+	var yes bool
+	cmd := &cobra.Command{
+		Use:   "cancel-pending [product]",
+		Short: "Cancel all change sets of a product that are still PREPARING or APPLYING",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(_ *cobra.Command, args []string) error {
+			return cancelPending(args[0], yes)
+		},
+	}
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "Skip the confirmation prompt")
+	return cmd
+}
+
+func planCmd() *cobra.Command {
+	var noColor bool
+	cmd := &cobra.Command{
+		Use:   "plan [product]",
+		Short: "Show what update and push-version would change on the live product, exiting with code 2 if anything differs",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			changed, err := planProduct(args[0], !noColor && useColor())
 			if err != nil {
 				return err
 			}
-			return releaseVersion(args[0], args[1], image, notes, baseVersion, noOp)
+			if changed {
+				// Pending changes are a normal outcome, not a usage error.
+				cmd.SilenceErrors, cmd.SilenceUsage = true, true
+				return &exitCodeError{code: 2}
+			}
+			return nil
 		},
 	}
+	cmd.Flags().BoolVar(&noColor, "no-color", false, "Disable coloured output")
+	return cmd
+}
 
-	cmd.Flags().StringVar(&image, "image", "", "Docker image URI (required)")
-	cmd.Flags().StringVar(&releaseNotes, "release-notes", "", "Release notes text")
-	cmd.Flags().StringVar(&releaseNotesFile, "release-notes-file", "", "Path to file containing release notes")
-	cmd.Flags().StringVar(&baseVersion, "base-version", "", "Base version to clone from (auto-detects latest if not specified)")
-	cmd.Flags().BoolVar(&noOp, "no-op", false, "Print the changeset JSON to stdout without creating the changeset")
+func driftCmd() *cobra.Command {
+	var format string
+	var noColor bool
+	cmd := &cobra.Command{
+		Use:   "drift",
+		Short: "Compare every product under data/ with the live listing, exiting with code 2 if any product drifted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			report, err := detectDrift(format, !noColor && useColor())
+			if err != nil {
+				return err
+			}
+			if report.errored() > 0 {
+				return fmt.Errorf("drift detection failed for %d product(s)", report.errored())
+			}
+			if report.drifted() > 0 {
+				cmd.SilenceErrors, cmd.SilenceUsage = true, true
+				return &exitCodeError{code: 2}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "text", "Report format: text, json or junit")
+	cmd.Flags().BoolVar(&noColor, "no-color", false, "Disable coloured output")
 	return cmd
 }
 
@@ -144,11 +433,25 @@ func mainFunc() {
 		dumpProductCmd(),
 		updateProductCmd(),
 		dumpVersionsCmd(),
+		listVersionsCmd(),
 		addVersionCmd(),
+		updateVersionCmd(),
+		restrictVersionCmd(),
 		cloneProductCmd(),
 		releaseCmd(),
+		changeSetCmd(),
+		cancelPendingCmd(),
+		planCmd(),
+		driftCmd(),
 	)
 	if err := rootCmd.Execute(); err != nil {
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			if exitErr.msg != "" {
+				fmt.Println(exitErr.msg)
+			}
+			os.Exit(exitErr.code)
+		}
 		fmt.Println(err)
 		os.Exit(1)
 	}
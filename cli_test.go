@@ -10,7 +10,7 @@ import (
 
 func TestLoadReleaseNotes(t *testing.T) {
 	t.Run("from inline string", func(t *testing.T) {
-		got, err := loadReleaseNotes("My notes", "")
+		got, err := loadReleaseNotes(releaseNotesOptions{text: "My notes"}, "")
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -24,7 +24,7 @@ func TestLoadReleaseNotes(t *testing.T) {
 		filePath := filepath.Join(tmpDir, "notes.txt")
 		_ = os.WriteFile(filePath, []byte("file content"), 0o644)
 
-		got, err := loadReleaseNotes("inline content", filePath)
+		got, err := loadReleaseNotes(releaseNotesOptions{text: "inline content", file: filePath}, "")
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -38,7 +38,7 @@ func TestLoadReleaseNotes(t *testing.T) {
 		filePath := filepath.Join(tmpDir, "notes.txt")
 		_ = os.WriteFile(filePath, []byte("notes from file"), 0o644)
 
-		got, err := loadReleaseNotes("", filePath)
+		got, err := loadReleaseNotes(releaseNotesOptions{file: filePath}, "")
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -48,14 +48,14 @@ func TestLoadReleaseNotes(t *testing.T) {
 	})
 
 	t.Run("missing file returns error", func(t *testing.T) {
-		_, err := loadReleaseNotes("", "/nonexistent/notes.txt")
+		_, err := loadReleaseNotes(releaseNotesOptions{file: "/nonexistent/notes.txt"}, "")
 		if err == nil {
 			t.Fatal("expected error")
 		}
 	})
 
 	t.Run("neither notes nor file returns error", func(t *testing.T) {
-		_, err := loadReleaseNotes("", "")
+		_, err := loadReleaseNotes(releaseNotesOptions{}, "")
 		if err == nil {
 			t.Fatal("expected error")
 		}
@@ -66,11 +66,21 @@ func TestCommandBuilders(t *testing.T) {
 	builders := []func() *cobra.Command{
 		dumpVersionsCmd,
 		addVersionCmd,
+		listVersionsCmd,
+		updateVersionCmd,
+		restrictVersionCmd,
 		dumpProductCmd,
 		listProductsCmd,
 		updateProductCmd,
 		cloneProductCmd,
 		releaseCmd,
+		changeSetCmd,
+		describeChangeSetCmd,
+		listChangeSetsCmd,
+		cancelChangeSetCmd,
+		cancelPendingCmd,
+		planCmd,
+		driftCmd,
 	}
 	for _, b := range builders {
 		cmd := b()
@@ -83,3 +93,25 @@ func TestCommandBuilders(t *testing.T) {
 		}
 	}
 }
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"y\n", true},
+		{"YES\n", true},
+		{"yes", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			withConfirmInput(t, tc.input)
+			if got := confirm("Proceed?"); got != tc.want {
+				t.Errorf("confirm() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Delivery option types with their own details block. Options of any other type are sent as plain ECR image options.
+const (
+	deliveryOptionTypeHelm     = "Helm"
+	deliveryOptionTypeEksAddOn = "EksAddOn"
+	deliveryOptionTypeAmi      = "AmazonMachineImage"
+	deliveryOptionTypeCfn      = "CloudFormation"
+)
+
+// isServerDeliveryOption reports whether a delivery option launches an AMI, which only server products support.
+func isServerDeliveryOption(opt Deliveryoptions) bool {
+	return strings.EqualFold(opt.Type, deliveryOptionTypeAmi) || strings.EqualFold(opt.Type, deliveryOptionTypeCfn)
+}
+
+// Helm is the Helm chart section of a delivery option in the version YAML.
+type Helm struct {
+	Charturi                      string               `json:"charturi"`
+	Releasename                   string               `json:"releasename"`
+	Namespace                     string               `json:"namespace"`
+	Quicklaunchenabled            bool                 `json:"quicklaunchenabled"`
+	Marketplaceserviceaccountname string               `json:"marketplaceserviceaccountname"`
+	Overrideparameters            []Overrideparameters `json:"overrideparameters"`
+}
+
+// Overrideparameters is a chart value buyers can set when they launch the product.
+type Overrideparameters struct {
+	Key          string   `json:"key"`
+	Defaultvalue string   `json:"defaultvalue"`
+	Metadata     Metadata `json:"metadata"`
+}
+
+type Metadata struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Obfuscate   bool   `json:"obfuscate"`
+}
+
+type HelmDeliveryOptionDetails struct {
+	CompatibleServices            []string              `json:"CompatibleServices"`
+	ContainerImages               []string              `json:"ContainerImages"`
+	HelmChartURI                  string                `json:"HelmChartUri"`
+	Description                   string                `json:"Description"`
+	UsageInstructions             string                `json:"UsageInstructions"`
+	DeploymentResources           []DeploymentResources `json:"DeploymentResources"`
+	QuickLaunchEnabled            bool                  `json:"QuickLaunchEnabled"`
+	MarketplaceServiceAccountName string                `json:"MarketplaceServiceAccountName,omitempty"`
+	ReleaseName                   string                `json:"ReleaseName,omitempty"`
+	Namespace                     string                `json:"Namespace,omitempty"`
+	OverrideParameters            []OverrideParameters  `json:"OverrideParameters,omitempty"`
+}
+
+type OverrideParameters struct {
+	Key          string             `json:"Key"`
+	DefaultValue string             `json:"DefaultValue"`
+	Metadata     *ParameterMetadata `json:"Metadata,omitempty"`
+}
+
+type ParameterMetadata struct {
+	Label       string `json:"Label,omitempty"`
+	Description string `json:"Description,omitempty"`
+	Obfuscate   bool   `json:"Obfuscate"`
+}
+
+func convertHelmDeliveryOption(opt Deliveryoptions, source Sources) *HelmDeliveryOptionDetails {
+	params := make([]OverrideParameters, 0, len(opt.Helm.Overrideparameters))
+	for _, p := range opt.Helm.Overrideparameters {
+		param := OverrideParameters{Key: p.Key, DefaultValue: p.Defaultvalue}
+		if p.Metadata != (Metadata{}) {
+			param.Metadata = &ParameterMetadata{
+				Label:       p.Metadata.Label,
+				Description: p.Metadata.Description,
+				Obfuscate:   p.Metadata.Obfuscate,
+			}
+		}
+		params = append(params, param)
+	}
+	return &HelmDeliveryOptionDetails{
+		CompatibleServices:            compatibleServices(opt, source),
+		ContainerImages:               source.Images,
+		HelmChartURI:                  opt.Helm.Charturi,
+		Description:                   opt.Shortdescription,
+		UsageInstructions:             opt.Instructions.Usage,
+		DeploymentResources:           deploymentResources(opt),
+		QuickLaunchEnabled:            opt.Helm.Quicklaunchenabled,
+		MarketplaceServiceAccountName: opt.Helm.Marketplaceserviceaccountname,
+		ReleaseName:                   opt.Helm.Releasename,
+		Namespace:                     opt.Helm.Namespace,
+		OverrideParameters:            params,
+	}
+}
+
+// Eksaddon is the Amazon EKS add-on section of a delivery option in the version YAML.
+type Eksaddon struct {
+	Name               string   `json:"name"`
+	Version            string   `json:"version"`
+	Namespace          string   `json:"namespace"`
+	Kubernetesversions []string `json:"kubernetesversions"`
+}
+
+type EksAddOnDeliveryOptionDetails struct {
+	AddOnName                   string                `json:"AddOnName"`
+	AddOnVersion                string                `json:"AddOnVersion"`
+	Namespace                   string                `json:"Namespace,omitempty"`
+	SupportedKubernetesVersions []string              `json:"SupportedKubernetesVersions"`
+	ContainerImages             []string              `json:"ContainerImages"`
+	CompatibleServices          []string              `json:"CompatibleServices"`
+	Description                 string                `json:"Description"`
+	UsageInstructions           string                `json:"UsageInstructions"`
+	DeploymentResources         []DeploymentResources `json:"DeploymentResources"`
+}
+
+func convertEksAddOnDeliveryOption(opt Deliveryoptions, source Sources) *EksAddOnDeliveryOptionDetails {
+	return &EksAddOnDeliveryOptionDetails{
+		AddOnName:                   opt.Eksaddon.Name,
+		AddOnVersion:                opt.Eksaddon.Version,
+		Namespace:                   opt.Eksaddon.Namespace,
+		SupportedKubernetesVersions: opt.Eksaddon.Kubernetesversions,
+		ContainerImages:             source.Images,
+		CompatibleServices:          compatibleServices(opt, source),
+		Description:                 opt.Shortdescription,
+		UsageInstructions:           opt.Instructions.Usage,
+		DeploymentResources:         deploymentResources(opt),
+	}
+}
+
+func validateEksAddOn(opt Deliveryoptions) error {
+	switch {
+	case opt.Eksaddon.Name == "":
+		return fmt.Errorf("EKS add-on delivery option %q has no eksaddon.name", opt.Title)
+	case opt.Eksaddon.Version == "":
+		return fmt.Errorf("EKS add-on delivery option %q has no eksaddon.version", opt.Title)
+	case len(opt.Eksaddon.Kubernetesversions) == 0:
+		return fmt.Errorf("EKS add-on delivery option %q has no eksaddon.kubernetesversions", opt.Title)
+	}
+	return nil
+}
+
+// validateDeliveryOption checks the settings the API requires for the option's type before anything is sent.
+func validateDeliveryOption(opt Deliveryoptions) error {
+	switch {
+	case strings.EqualFold(opt.Type, deliveryOptionTypeHelm) && opt.Helm.Charturi == "":
+		return fmt.Errorf("helm delivery option %q has no helm.charturi", opt.Title)
+	case strings.EqualFold(opt.Type, deliveryOptionTypeEksAddOn):
+		return validateEksAddOn(opt)
+	case strings.EqualFold(opt.Type, deliveryOptionTypeCfn):
+		return validateCloudformation(opt)
+	}
+	return nil
+}
+
+// validateOptionSource checks that the source of an AMI or CloudFormation option can be published.
+func validateOptionSource(opt Deliveryoptions, source Sources) error {
+	if !isServerDeliveryOption(opt) {
+		return nil
+	}
+	return validateAmiSource(source)
+}
+
+// checkDeliveryOptionTypes rejects delivery options the product type cannot publish: server products only take
+// AMI and CloudFormation options, and container products take everything else.
+func checkDeliveryOptionTypes(productType string, opts []Deliveryoptions) error {
+	for _, opt := range opts {
+		isServer := isServerDeliveryOption(opt)
+		if productType == productTypeServer && !isServer {
+			return fmt.Errorf("delivery option %q has type %q, but %s versions only support %s and %s options",
+				opt.Title, opt.Type, productTypeServer, deliveryOptionTypeAmi, deliveryOptionTypeCfn)
+		}
+		if productType != productTypeServer && isServer {
+			return fmt.Errorf("delivery option %q has type %q, which only %s products support", opt.Title, opt.Type, productTypeServer)
+		}
+	}
+	return nil
+}
+
+// setAddOnVersions sets eksaddon.version in every EKS add-on delivery option of a version document.
+func setAddOnVersions(doc *yamlDocument, addOnVersion string) error {
+	options, _ := doc.field(doc.top(), "deliveryoptions")
+	found := false
+	for _, opt := range doc.items(options) {
+		optType, _ := doc.field(opt, "type")
+		if optType.node == nil || !strings.EqualFold(optType.node.Value, deliveryOptionTypeEksAddOn) {
+			continue
+		}
+		found = true
+		addOn, _ := doc.field(opt, "eksaddon")
+		version, ok := doc.field(addOn, "version")
+		if !ok {
+			return fmt.Errorf("line %d: EKS add-on delivery option has no eksaddon.version to update", opt.node.Line)
+		}
+		if err := doc.setString(version, addOnVersion); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return errors.New("the version has no EKS add-on delivery option")
+	}
+	return nil
+}
+
+// deliveryDetailField is a setting DescribeEntity returns directly on a delivery option, with its key in the
+// section of the version YAML. The keys inside values of fields with a valueType are lowercased like the YAML.
+type deliveryDetailField struct {
+	apiKey    string
+	yamlKey   string
+	valueType reflect.Type
+}
+
+// deliveryDetailSection is the section of the version YAML holding the settings of one delivery option type.
+type deliveryDetailSection struct {
+	optionType string
+	key        string
+	fields     []deliveryDetailField
+}
+
+// deliveryDetailSections is where the flat settings DescribeEntity returns for a delivery option meet the
+// sections push and release read, such as helm.charturi for the HelmChartUri of a Helm option.
+var deliveryDetailSections = []deliveryDetailSection{
+	{deliveryOptionTypeHelm, "helm", []deliveryDetailField{
+		{"HelmChartUri", "charturi", nil},
+		{"ReleaseName", "releasename", nil},
+		{"Namespace", "namespace", nil},
+		{"QuickLaunchEnabled", "quicklaunchenabled", nil},
+		{"MarketplaceServiceAccountName", "marketplaceserviceaccountname", nil},
+		{"OverrideParameters", "overrideparameters", reflect.TypeFor[[]OverrideParameters]()},
+	}},
+	{deliveryOptionTypeEksAddOn, "eksaddon", []deliveryDetailField{
+		{"AddOnName", "name", nil},
+		{"AddOnVersion", "version", nil},
+		{"Namespace", "namespace", nil},
+		{"SupportedKubernetesVersions", "kubernetesversions", nil},
+	}},
+	{deliveryOptionTypeCfn, "cloudformation", []deliveryDetailField{
+		{"Template", "templateurl", nil},
+		{"ArchitectureDiagram", "architecturediagramurl", nil},
+		{"AmiAliasParameterMappings", "amiparameters", reflect.TypeFor[[]AmiAliasParameterMapping]()},
+	}},
+}
+
+// detailSection returns the section of the version YAML holding the settings of a delivery option type.
+func detailSection(optType any) (deliveryDetailSection, bool) {
+	for _, section := range deliveryDetailSections {
+		if strings.EqualFold(fmt.Sprint(optType), section.optionType) {
+			return section, true
+		}
+	}
+	return deliveryDetailSection{}, false
+}
+
+func (s deliveryDetailSection) field(apiKey string) (deliveryDetailField, bool) {
+	for _, f := range s.fields {
+		if f.apiKey == apiKey {
+			return f, true
+		}
+	}
+	return deliveryDetailField{}, false
+}
+
+// nestDeliveryDetails moves the settings DescribeEntity returns directly on a delivery option into the section of
+// its type in the version YAML. Options of other types, and settings not listed there, are left as they are.
+func nestDeliveryDetails(opt yaml.MapSlice) yaml.MapSlice {
+	optType, _ := mapSliceValue(opt, "type")
+	section, ok := detailSection(optType)
+	if !ok {
+		return opt
+	}
+	out := make(yaml.MapSlice, 0, len(opt)+1)
+	var nested yaml.MapSlice
+	for _, item := range opt {
+		if f, ok := section.field(fmt.Sprint(item.Key)); ok {
+			nested = append(nested, yaml.MapItem{Key: f.yamlKey, Value: renameKeys(item.Value, f.valueType, true)})
+			continue
+		}
+		out = append(out, item)
+	}
+	if len(nested) == 0 {
+		return opt
+	}
+	return append(out, yaml.MapItem{Key: section.key, Value: nested})
+}
+
+// nestVersionDeliveryDetails applies nestDeliveryDetails to every delivery option of a version document.
+func nestVersionDeliveryDetails(doc yaml.MapSlice) yaml.MapSlice {
+	options, _ := mapSliceValue(doc, "deliveryoptions")
+	items, ok := options.([]any)
+	if !ok {
+		return doc
+	}
+	nested := make([]any, 0, len(items))
+	for _, opt := range items {
+		if o, ok := opt.(yaml.MapSlice); ok {
+			opt = nestDeliveryDetails(o)
+		}
+		nested = append(nested, opt)
+	}
+	out := make(yaml.MapSlice, 0, len(doc))
+	for _, item := range doc {
+		if item.Key == "deliveryoptions" {
+			item.Value = nested
+		}
+		out = append(out, item)
+	}
+	return out
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestConvertHelmDeliveryOption(t *testing.T) {
+	opt := Deliveryoptions{
+		Type:             "Helm",
+		Title:            "Helm chart",
+		Shortdescription: "Install on EKS",
+		Instructions:     Instructions{Usage: "helm install app oci://..."},
+		Compatibility:    ServicesCompatibility{Awsservices: []string{"EKS"}},
+		Helm: Helm{
+			Charturi:                      "123.dkr.ecr.us-east-1.amazonaws.com/charts/app:1.0",
+			Releasename:                   "app",
+			Namespace:                     "app-system",
+			Quicklaunchenabled:            true,
+			Marketplaceserviceaccountname: "app-sa",
+			Overrideparameters: []Overrideparameters{
+				{Key: "replicas", Defaultvalue: "2"},
+				{Key: "apiKey", Metadata: Metadata{Label: "API key", Obfuscate: true}},
+			},
+		},
+	}
+	got := convertDeliveryOption(opt, Sources{Images: []string{"app:1.0"}})
+
+	if got.Details.EcrDeliveryOptionDetails != nil {
+		t.Error("helm option should not carry ECR details")
+	}
+	helm := got.Details.HelmDeliveryOptionDetails
+	if helm == nil {
+		t.Fatal("expected helm details")
+	}
+	if helm.HelmChartURI != opt.Helm.Charturi || helm.ReleaseName != "app" || helm.Namespace != "app-system" {
+		t.Errorf("helm details = %+v", helm)
+	}
+	if !helm.QuickLaunchEnabled || helm.MarketplaceServiceAccountName != "app-sa" {
+		t.Errorf("quick launch settings = %+v", helm)
+	}
+	if len(helm.ContainerImages) != 1 || helm.ContainerImages[0] != "app:1.0" {
+		t.Errorf("images = %v", helm.ContainerImages)
+	}
+	if len(helm.OverrideParameters) != 2 {
+		t.Fatalf("override parameters = %+v", helm.OverrideParameters)
+	}
+	if helm.OverrideParameters[0].Metadata != nil {
+		t.Error("parameter without metadata should omit it")
+	}
+	if m := helm.OverrideParameters[1].Metadata; m == nil || m.Label != "API key" || !m.Obfuscate {
+		t.Errorf("metadata = %+v", m)
+	}
+
+	payload, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(payload), `"HelmChartUri":"123.dkr.ecr.us-east-1.amazonaws.com/charts/app:1.0"`) {
+		t.Errorf("payload = %s", payload)
+	}
+}
+
+func TestConvertToDstMixedDeliveryOptions(t *testing.T) {
+	src := YAMLVersionData{
+		Sources: []Sources{{ID: "src-1", Images: []string{"app:1.0"}}},
+		Deliveryoptions: []Deliveryoptions{
+			{Title: "Images", Type: "ECR", Sourceid: "src-1"},
+			{Title: "Chart", Type: "helm", Sourceid: "src-1", Helm: Helm{Charturi: "charts/app:1.0"}},
+		},
+	}
+	dst, err := src.convertToDst()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dst.DeliveryOptions[0].Details.EcrDeliveryOptionDetails == nil {
+		t.Error("first option should be an ECR option")
+	}
+	if dst.DeliveryOptions[1].Details.HelmDeliveryOptionDetails == nil {
+		t.Error("second option should be a Helm option")
+	}
+}
+
+func TestValidateDeliveryOption(t *testing.T) {
+	if err := validateDeliveryOption(Deliveryoptions{Title: "Chart", Type: "Helm"}); err == nil {
+		t.Error("expected error for helm option without chart URI")
+	}
+	if err := validateDeliveryOption(Deliveryoptions{Title: "Images", Type: "ECR"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	src := YAMLVersionData{Deliveryoptions: []Deliveryoptions{{Title: "Chart", Type: "Helm"}}}
+	if _, err := src.convertToDst(); err == nil {
+		t.Error("convertToDst should reject invalid delivery options")
+	}
+}
+
+func TestConvertEksAddOnDeliveryOption(t *testing.T) {
+	opt := Deliveryoptions{
+		Type:             "EksAddOn",
+		Title:            "EKS add-on",
+		Shortdescription: "Install as an EKS add-on",
+		Eksaddon: Eksaddon{
+			Name:               "acme-agent",
+			Version:            "v1.1.0-eksbuild.1",
+			Namespace:          "acme",
+			Kubernetesversions: []string{"1.29", "1.30"},
+		},
+	}
+	got := convertDeliveryOption(opt, Sources{Images: []string{"agent:1.1"}, Compatibility: SourceCompatibility{Awsservices: []string{"EKS"}}})
+
+	addOn := got.Details.EksAddOnDeliveryOptionDetails
+	if addOn == nil || got.Details.EcrDeliveryOptionDetails != nil {
+		t.Fatalf("details = %+v", got.Details)
+	}
+	if addOn.AddOnName != "acme-agent" || addOn.AddOnVersion != "v1.1.0-eksbuild.1" || addOn.Namespace != "acme" {
+		t.Errorf("add-on details = %+v", addOn)
+	}
+	if len(addOn.SupportedKubernetesVersions) != 2 || len(addOn.ContainerImages) != 1 || addOn.CompatibleServices[0] != "EKS" {
+		t.Errorf("add-on details = %+v", addOn)
+	}
+}
+
+func TestValidateEksAddOn(t *testing.T) {
+	valid := Eksaddon{Name: "a", Version: "v1", Kubernetesversions: []string{"1.30"}}
+	tests := []struct {
+		name    string
+		addOn   Eksaddon
+		wantErr string
+	}{
+		{"valid", valid, ""},
+		{"missing name", Eksaddon{Version: "v1", Kubernetesversions: []string{"1.30"}}, "eksaddon.name"},
+		{"missing version", Eksaddon{Name: "a", Kubernetesversions: []string{"1.30"}}, "eksaddon.version"},
+		{"missing kubernetes versions", Eksaddon{Name: "a", Version: "v1"}, "eksaddon.kubernetesversions"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateDeliveryOption(Deliveryoptions{Type: "EksAddOn", Eksaddon: tc.addOn})
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v, want mention of %s", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetAddOnVersions(t *testing.T) {
+	src := `deliveryoptions:
+- type: ECR
+  title: Images
+- type: EksAddOn
+  title: Add-on
+  eksaddon:
+    name: acme-agent
+    version: v1.0.0-eksbuild.1 # bumped by release
+`
+	doc, err := parseYAMLDocument([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setAddOnVersions(doc, "v1.1.0-eksbuild.1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := strings.Replace(src, "v1.0.0-eksbuild.1", "v1.1.0-eksbuild.1", 1)
+	if got := string(doc.bytes()); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	doc, _ = parseYAMLDocument([]byte("deliveryoptions:\n- type: ECR\n"))
+	if err := setAddOnVersions(doc, "v2"); err == nil {
+		t.Error("expected error when the version has no EKS add-on option")
+	}
+	doc, _ = parseYAMLDocument([]byte("deliveryoptions:\n- type: EksAddOn\n  eksaddon:\n    name: a\n"))
+	if err := setAddOnVersions(doc, "v2"); err == nil {
+		t.Error("expected error when the add-on has no version key")
+	}
+}
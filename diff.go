@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// fieldDiff is a single difference between a local YAML document and its live counterpart.
+type fieldDiff struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"`
+	Local  any    `json:"local,omitempty"`
+	Remote any    `json:"remote,omitempty"`
+}
+
+// toGeneric converts v into the plain maps, slices and scalars of its YAML representation,
+// so documents loaded from disk and from the API can be compared field by field.
+func toGeneric(v any) (any, error) {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var generic any
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+	return normalizeYAML(generic), nil
+}
+
+// normalizeYAML turns the map[interface{}]interface{} values produced by yaml.v2 into map[string]any.
+func normalizeYAML(v any) any {
+	switch t := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = normalizeYAML(val)
+		}
+		return m
+	case []any:
+		for i := range t {
+			t[i] = normalizeYAML(t[i])
+		}
+		return t
+	}
+	return v
+}
+
+// diffDocuments returns the field-level differences between the local and remote documents.
+func diffDocuments(local, remote any) ([]fieldDiff, error) {
+	l, err := toGeneric(local)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toGeneric(remote)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []fieldDiff
+	diffValues("", l, r, &diffs)
+	return diffs, nil
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func isEmptyValue(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	}
+	return false
+}
+
+func diffValues(path string, local, remote any, diffs *[]fieldDiff) {
+	if isEmptyValue(local) && isEmptyValue(remote) {
+		return
+	}
+	lm, lok := local.(map[string]any)
+	rm, rok := remote.(map[string]any)
+	if lok && rok {
+		diffMaps(path, lm, rm, diffs)
+		return
+	}
+	ll, lok := local.([]any)
+	rl, rok := remote.([]any)
+	if lok && rok {
+		diffLists(path, ll, rl, diffs)
+		return
+	}
+	diffScalars(path, local, remote, diffs)
+}
+
+func diffMaps(path string, local, remote map[string]any, diffs *[]fieldDiff) {
+	keys := make([]string, 0, len(local)+len(remote))
+	for k := range local {
+		keys = append(keys, k)
+	}
+	for k := range remote {
+		if _, ok := local[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		diffValues(joinPath(path, k), local[k], remote[k], diffs)
+	}
+}
+
+func isScalarList(items []any) bool {
+	for _, item := range items {
+		switch item.(type) {
+		case map[string]any, []any:
+			return false
+		}
+	}
+	return true
+}
+
+// diffLists reports lists of scalars (highlights, keywords, categories) as added and removed items,
+// and compares lists of objects element by element.
+func diffLists(path string, local, remote []any, diffs *[]fieldDiff) {
+	if reflect.DeepEqual(local, remote) {
+		return
+	}
+	if isScalarList(local) && isScalarList(remote) {
+		diffScalarLists(path, local, remote, diffs)
+		return
+	}
+	if len(local) != len(remote) {
+		*diffs = append(*diffs, fieldDiff{Path: path, Kind: diffChanged, Local: local, Remote: remote})
+		return
+	}
+	for i := range local {
+		diffValues(fmt.Sprintf("%s[%d]", path, i), local[i], remote[i], diffs)
+	}
+}
+
+func diffScalarLists(path string, local, remote []any, diffs *[]fieldDiff) {
+	before := len(*diffs)
+	for _, item := range remote {
+		if !slices.Contains(local, item) {
+			*diffs = append(*diffs, fieldDiff{Path: path, Kind: diffRemoved, Remote: item})
+		}
+	}
+	for _, item := range local {
+		if !slices.Contains(remote, item) {
+			*diffs = append(*diffs, fieldDiff{Path: path, Kind: diffAdded, Local: item})
+		}
+	}
+	if len(*diffs) == before {
+		// Same items in a different order.
+		*diffs = append(*diffs, fieldDiff{Path: path, Kind: diffChanged, Local: local, Remote: remote})
+	}
+}
+
+func diffScalars(path string, local, remote any, diffs *[]fieldDiff) {
+	switch {
+	case isEmptyValue(remote):
+		*diffs = append(*diffs, fieldDiff{Path: path, Kind: diffAdded, Local: local})
+	case isEmptyValue(local):
+		*diffs = append(*diffs, fieldDiff{Path: path, Kind: diffRemoved, Remote: remote})
+	case !reflect.DeepEqual(local, remote):
+		*diffs = append(*diffs, fieldDiff{Path: path, Kind: diffChanged, Local: local, Remote: remote})
+	}
+}
+
+const (
+	ansiRed   = "\033[31m"
+	ansiGreen = "\033[32m"
+	ansiReset = "\033[0m"
+)
+
+// useColor reports whether diff output should be colourised: only on a terminal, and never when NO_COLOR is set.
+func useColor() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// diffPrinter renders diffs as +/- lines, optionally coloured green and red.
+type diffPrinter struct {
+	color bool
+}
+
+func (p diffPrinter) line(prefix, text string) string {
+	out := "  " + prefix + " " + text
+	if !p.color {
+		return out
+	}
+	switch prefix {
+	case "+":
+		return ansiGreen + out + ansiReset
+	case "-":
+		return ansiRed + out + ansiReset
+	}
+	return out
+}
+
+func formatDiffValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimRight(string(data), "\n")
+}
+
+// render returns the lines describing a single diff, with multi-line text compared line by line.
+func (p diffPrinter) render(d fieldDiff) []string {
+	switch d.Kind {
+	case diffAdded:
+		return p.block("+", formatDiffValue(d.Local))
+	case diffRemoved:
+		return p.block("-", formatDiffValue(d.Remote))
+	}
+	local, remote := formatDiffValue(d.Local), formatDiffValue(d.Remote)
+	var lines []string
+	for _, l := range diffLines(strings.Split(remote, "\n"), strings.Split(local, "\n")) {
+		lines = append(lines, p.line(l.prefix, l.text))
+	}
+	return lines
+}
+
+func (p diffPrinter) block(prefix, text string) []string {
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		lines = append(lines, p.line(prefix, l))
+	}
+	return lines
+}
+
+// renderAll returns the lines describing all diffs, grouped under their field path.
+func (p diffPrinter) renderAll(diffs []fieldDiff) []string {
+	var lines []string
+	lastPath := ""
+	for _, d := range diffs {
+		if d.Path != lastPath {
+			lines = append(lines, d.Path+":")
+			lastPath = d.Path
+		}
+		lines = append(lines, p.render(d)...)
+	}
+	return lines
+}
+
+// printDiffs prints the diffs grouped by field path.
+func (p diffPrinter) printDiffs(diffs []fieldDiff) {
+	for _, l := range p.renderAll(diffs) {
+		fmt.Println(l)
+	}
+}
+
+type diffLine struct {
+	prefix string
+	text   string
+}
+
+// lcsTable returns the lengths of the longest common subsequences of every pair of suffixes of a and b.
+func lcsTable(a, b []string) [][]int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	return lcs
+}
+
+// diffLines computes a line diff turning before into after. Unchanged lines are kept for context with a blank prefix.
+func diffLines(before, after []string) []diffLine {
+	lcs := lcsTable(before, after)
+	var out []diffLine
+	i, j := 0, 0
+	for i < len(before) && j < len(after) {
+		switch {
+		case before[i] == after[j]:
+			out = append(out, diffLine{" ", before[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, diffLine{"-", before[i]})
+			i++
+		default:
+			out = append(out, diffLine{"+", after[j]})
+			j++
+		}
+	}
+	for _, l := range before[i:] {
+		out = append(out, diffLine{"-", l})
+	}
+	for _, l := range after[j:] {
+		out = append(out, diffLine{"+", l})
+	}
+	return out
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffDocuments(t *testing.T) {
+	t.Run("identical documents have no diffs", func(t *testing.T) {
+		doc := map[string]any{"title": "A", "highlights": []string{"x", "y"}}
+		diffs, err := diffDocuments(doc, doc)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(diffs) != 0 {
+			t.Errorf("diffs = %+v, want none", diffs)
+		}
+	})
+
+	t.Run("scalar lists report added and removed items", func(t *testing.T) {
+		local := map[string]any{"highlights": []string{"kept", "new"}}
+		remote := map[string]any{"highlights": []string{"kept", "old"}}
+		diffs, err := diffDocuments(local, remote)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(diffs) != 2 {
+			t.Fatalf("diffs = %+v, want 2", diffs)
+		}
+		if diffs[0].Kind != diffRemoved || diffs[0].Remote != "old" {
+			t.Errorf("diffs[0] = %+v", diffs[0])
+		}
+		if diffs[1].Kind != diffAdded || diffs[1].Local != "new" {
+			t.Errorf("diffs[1] = %+v", diffs[1])
+		}
+	})
+
+	t.Run("reordered list is a change", func(t *testing.T) {
+		diffs, _ := diffDocuments(map[string]any{"k": []string{"a", "b"}}, map[string]any{"k": []string{"b", "a"}})
+		if len(diffs) != 1 || diffs[0].Kind != diffChanged {
+			t.Errorf("diffs = %+v", diffs)
+		}
+	})
+
+	t.Run("nested fields are reported by path", func(t *testing.T) {
+		local := map[string]any{"description": map[string]any{"producttitle": "New", "sku": ""}}
+		remote := map[string]any{"description": map[string]any{"producttitle": "Old"}}
+		diffs, _ := diffDocuments(local, remote)
+		if len(diffs) != 1 {
+			t.Fatalf("diffs = %+v, want 1", diffs)
+		}
+		if diffs[0].Path != "description.producttitle" || diffs[0].Kind != diffChanged {
+			t.Errorf("diff = %+v", diffs[0])
+		}
+	})
+
+	t.Run("lists of objects are compared element by element", func(t *testing.T) {
+		local := map[string]any{"videos": []map[string]string{{"url": "a"}, {"url": "b2"}}}
+		remote := map[string]any{"videos": []map[string]string{{"url": "a"}, {"url": "b"}}}
+		diffs, _ := diffDocuments(local, remote)
+		if len(diffs) != 1 || diffs[0].Path != "videos[1].url" {
+			t.Errorf("diffs = %+v", diffs)
+		}
+	})
+
+	t.Run("empty and missing values are equal", func(t *testing.T) {
+		diffs, _ := diffDocuments(map[string]any{"a": "", "b": []string{}}, map[string]any{})
+		if len(diffs) != 0 {
+			t.Errorf("diffs = %+v, want none", diffs)
+		}
+	})
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines(
+		strings.Split("intro\nold line\noutro", "\n"),
+		strings.Split("intro\nnew line\noutro\nextra", "\n"),
+	)
+	var rendered []string
+	for _, l := range got {
+		rendered = append(rendered, l.prefix+l.text)
+	}
+	want := " intro|-old line|+new line| outro|+extra"
+	if strings.Join(rendered, "|") != want {
+		t.Errorf("diff = %q, want %q", strings.Join(rendered, "|"), want)
+	}
+}
+
+func TestDiffPrinterRender(t *testing.T) {
+	p := diffPrinter{}
+	lines := p.render(fieldDiff{Path: "description.longdescription", Kind: diffChanged, Local: "a\nb", Remote: "a\nc"})
+	if strings.Join(lines, "|") != "    a|  - c|  + b" {
+		t.Errorf("lines = %q", lines)
+	}
+
+	colored := diffPrinter{color: true}.render(fieldDiff{Kind: diffAdded, Local: "x"})
+	if len(colored) != 1 || !strings.HasPrefix(colored[0], ansiGreen) {
+		t.Errorf("colored = %q", colored)
+	}
+}
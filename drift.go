@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+	"gopkg.in/yaml.v2"
+)
+
+var driftFormats = []string{"text", "json", "junit"}
+
+// productDrift lists the fields of a product whose local YAML no longer matches the live entity.
+type productDrift struct {
+	Product  string      `json:"product"`
+	EntityID string      `json:"entityId,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Fields   []fieldDiff `json:"fields"`
+}
+
+// driftReport is the result of comparing every product directory under data/ with the live entities.
+type driftReport struct {
+	Products []productDrift `json:"products"`
+}
+
+func (r *driftReport) drifted() int {
+	n := 0
+	for i := range r.Products {
+		if len(r.Products[i].Fields) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *driftReport) errored() int {
+	n := 0
+	for i := range r.Products {
+		if r.Products[i].Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// localProducts lists the product directories under data/.
+func localProducts() ([]string, error) {
+	entries, err := os.ReadDir("data")
+	if err != nil {
+		return nil, fmt.Errorf("could not list products in data/: %w", err)
+	}
+	var products []string
+	for _, e := range entries {
+		if e.IsDir() {
+			products = append(products, e.Name())
+		}
+	}
+	sort.Strings(products)
+	return products, nil
+}
+
+// loadYAMLFile reads a YAML file as generic values, keeping fields the CLI does not model.
+func loadYAMLFile(path string) (any, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // G304: path is constructed internally from directories under data/
+	if err != nil {
+		return nil, err
+	}
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return normalizeYAML(doc), nil
+}
+
+func prefixDiffs(prefix string, diffs []fieldDiff) []fieldDiff {
+	for i := range diffs {
+		diffs[i].Path = joinPath(prefix, diffs[i].Path)
+	}
+	return diffs
+}
+
+// descriptionDrift compares description.yaml with the live entity, ignoring versions which have their own files.
+func descriptionDrift(productName string, remote *EntityDetails) ([]fieldDiff, error) {
+	local, err := loadYAMLFile(filepath.Join("data", productName, "description.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	remoteDoc, err := toGeneric(remote.descriptionDocument())
+	if err != nil {
+		return nil, err
+	}
+	var diffs []fieldDiff
+	diffValues("", local, remoteDoc, &diffs)
+	return diffs, nil
+}
+
+// versionsDrift compares each remote version with its local file. Local versions that were never published
+// are pending changes rather than drift, so they are left to plan.
+func versionsDrift(productName string, remote *EntityDetails) ([]fieldDiff, error) {
+	var diffs []fieldDiff
+	for i := range remote.Versions {
+		title := remote.Versions[i].VersionTitle
+		path := fmt.Sprintf("versions[%s]", title)
+		local, err := loadYAMLFile(filepath.Join("data", productName, "versions", title+".yaml"))
+		if errors.Is(err, os.ErrNotExist) {
+			diffs = append(diffs, fieldDiff{Path: path, Kind: diffRemoved, Remote: "version has no local file"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		remoteDoc, err := toGeneric(remote.versionDocument(&remote.Versions[i]))
+		if err != nil {
+			return nil, err
+		}
+		var versionDiffs []fieldDiff
+		diffValues("", local, remoteDoc, &versionDiffs)
+		diffs = append(diffs, prefixDiffs(path, versionDiffs)...)
+	}
+	return diffs, nil
+}
+
+func detectProductDrift(svc marketplaceClient, productName string) productDrift {
+	result := productDrift{Product: productName}
+	entityID, _, err := findProduct(svc, productName)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.EntityID = entityID
+
+	remote, err := describeProduct(svc, entityID)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	descDiffs, err := descriptionDrift(productName, remote)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	versionDiffs, err := versionsDrift(productName, remote)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Fields = append(descDiffs, versionDiffs...)
+	return result
+}
+
+func printDriftText(report *driftReport, printer diffPrinter) {
+	for i := range report.Products {
+		p := &report.Products[i]
+		switch {
+		case p.Error != "":
+			fmt.Printf("%s: error: %s\n", p.Product, p.Error)
+		case len(p.Fields) == 0:
+			fmt.Printf("%s: in sync\n", p.Product)
+		default:
+			fmt.Printf("%s: %d drifted field(s)\n", p.Product, len(p.Fields))
+			printer.printDiffs(p.Fields)
+		}
+	}
+	fmt.Printf("\n%d of %d product(s) drifted, %d error(s)\n", report.drifted(), len(report.Products), report.errored())
+}
+
+func printDriftJSON(report *driftReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+func driftTestCase(p *productDrift) junitTestCase {
+	tc := junitTestCase{ClassName: "drift", Name: p.Product}
+	if p.Error != "" {
+		tc.Error = &junitFailure{Message: p.Error}
+		return tc
+	}
+	if len(p.Fields) == 0 {
+		return tc
+	}
+	tc.Failure = &junitFailure{
+		Message: fmt.Sprintf("%d drifted field(s)", len(p.Fields)),
+		Text:    strings.Join(diffPrinter{}.renderAll(p.Fields), "\n"),
+	}
+	return tc
+}
+
+func printDriftJUnit(report *driftReport) error {
+	suite := junitTestSuite{
+		Name:     "aws-marketplace-drift",
+		Tests:    len(report.Products),
+		Failures: report.drifted(),
+		Errors:   report.errored(),
+	}
+	for i := range report.Products {
+		suite.TestCases = append(suite.TestCases, driftTestCase(&report.Products[i]))
+	}
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(xml.Header + string(data))
+	return nil
+}
+
+func printDriftReport(report *driftReport, format string, color bool) error {
+	switch format {
+	case "json":
+		return printDriftJSON(report)
+	case "junit":
+		return printDriftJUnit(report)
+	}
+	printDriftText(report, diffPrinter{color: color})
+	return nil
+}
+
+// driftWithClient compares every product under data/ with the live entity and prints the report in format.
+func driftWithClient(svc marketplaceClient, format string, color bool) (*driftReport, error) {
+	if !slices.Contains(driftFormats, format) {
+		return nil, fmt.Errorf("invalid format: %s. Valid formats are: %s", format, strings.Join(driftFormats, ", "))
+	}
+	products, err := localProducts()
+	if err != nil {
+		return nil, err
+	}
+	report := &driftReport{Products: make([]productDrift, 0, len(products))}
+	for _, productName := range products {
+		report.Products = append(report.Products, detectProductDrift(svc, productName))
+	}
+	return report, printDriftReport(report, format, color)
+}
+
+func detectDrift(format string, color bool) (*driftReport, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return driftWithClient(marketplacecatalog.NewFromConfig(cfg), format, color)
+}
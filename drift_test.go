@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestDriftWithClient(t *testing.T) {
+	// setup dumps remote as the local copy of MyProduct, then applies edit to the remote entity.
+	setup := func(t *testing.T, edit func(*EntityDetails)) *mockMarketplaceClient {
+		t.Helper()
+		remote := makeEntityDetailsWithVersion(t, "v1.0")
+		remote.Description.ProductTitle = "Title"
+		remote.Description.Highlights = []string{"fast"}
+
+		local := *remote
+		local.Versions = nil
+		writeLocalDescription(t, testProductName, &local)
+		dir := filepath.Join("data", testProductName, "versions")
+		_ = os.MkdirAll(dir, 0o755)
+		b, _ := yaml.Marshal(remote.Versions[0])
+		_ = os.WriteFile(filepath.Join(dir, "v1.0.yaml"), b, 0o644)
+
+		edit(remote)
+		return foundMock(t, testProductName, "eid-1", productTypeContainer, remote)
+	}
+
+	t.Run("in sync", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		svc := setup(t, func(*EntityDetails) {})
+		report, err := driftWithClient(svc, "text", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if report.drifted() != 0 || report.errored() != 0 {
+			t.Errorf("report = %+v, want no drift", report)
+		}
+	})
+
+	t.Run("freshly dumped product is in sync", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		payload := `{"Description":{"ProductTitle":"Title","Highlights":["fast"]},` +
+			`"Versions":[{"Id":"vid-1","VersionTitle":"v1.0","ReleaseNotes":"notes","CreationDate":"2024-01-01T00:00:00Z",` +
+			`"Sources":[{"Type":"DockerImages","Id":"src-1","Images":["ecr/app:1.0"]}],` +
+			`"DeliveryOptions":[{"Id":"do-1","Type":"Helm","SourceId":"src-1","Title":"Helm chart",` +
+			`"HelmChartUri":"ecr/chart:1.0","QuickLaunchEnabled":true}]}]}`
+		svc := payloadMock(testProductName, "eid-1", productTypeContainer, payload)
+		if err := dumpProductWithClient(svc, testProductName); err != nil {
+			t.Fatal(err)
+		}
+		if err := dumpVersionsWithClient(svc, testProductName); err != nil {
+			t.Fatal(err)
+		}
+		report, err := driftWithClient(svc, "json", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if report.drifted() != 0 || report.errored() != 0 {
+			t.Errorf("report = %+v, want no drift", report.Products)
+		}
+	})
+
+	t.Run("console edits are reported per field", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		svc := setup(t, func(d *EntityDetails) {
+			d.Description.Highlights = append(d.Description.Highlights, "edited in console")
+			d.Versions[0].ReleaseNotes = "hotfix notes"
+		})
+		report, err := driftWithClient(svc, "json", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if report.drifted() != 1 {
+			t.Fatalf("drifted = %d, want 1", report.drifted())
+		}
+		var paths []string
+		for _, f := range report.Products[0].Fields {
+			paths = append(paths, f.Path)
+		}
+		want := "description.highlights,versions[v1.0].releasenotes"
+		if strings.Join(paths, ",") != want {
+			t.Errorf("paths = %v, want %s", paths, want)
+		}
+	})
+
+	t.Run("remote version without local file", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		svc := setup(t, func(*EntityDetails) {})
+		_ = os.Remove(filepath.Join("data", testProductName, "versions", "v1.0.yaml"))
+		report, err := driftWithClient(svc, "junit", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if report.drifted() != 1 || report.Products[0].Fields[0].Path != "versions[v1.0]" {
+			t.Errorf("report = %+v", report.Products)
+		}
+	})
+
+	t.Run("unknown product recorded as error", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		svc := setup(t, func(*EntityDetails) {})
+		_ = os.MkdirAll(filepath.Join("data", "Retired"), 0o755)
+		report, err := driftWithClient(svc, "text", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(report.Products) != 2 || report.errored() != 1 || report.Products[1].Product != "Retired" {
+			t.Errorf("report = %+v", report.Products)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		if _, err := driftWithClient(&mockMarketplaceClient{}, "html", false); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("missing data directory", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		if _, err := driftWithClient(&mockMarketplaceClient{}, "text", false); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestDriftTestCase(t *testing.T) {
+	drifted := driftTestCase(&productDrift{
+		Product: testProductName,
+		Fields:  []fieldDiff{{Path: "description.producttitle", Kind: diffChanged, Local: "A", Remote: "B"}},
+	})
+	if drifted.Failure == nil || !strings.Contains(drifted.Failure.Text, "description.producttitle") {
+		t.Errorf("failure = %+v", drifted.Failure)
+	}
+
+	errored := driftTestCase(&productDrift{Product: testProductName, Error: "not found"})
+	if errored.Error == nil || errored.Failure != nil {
+		t.Errorf("test case = %+v", errored)
+	}
+
+	out, err := xml.Marshal(driftTestCase(&productDrift{Product: testProductName}))
+	if err != nil {
+		t.Fatalf("xml.Marshal: %v", err)
+	}
+	if strings.Contains(string(out), "failure") {
+		t.Errorf("in-sync product should pass: %s", out)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The YAML files use the lowercased JSON field names of the DescribeEntity Details (that is how yaml.v2 names
+// the EntityDetails fields), while fields the CLI does not model keep their original casing. Keys are only
+// renamed where EntityDetails models them, and only when they match the field name exactly, so a payload key
+// that happens to share a name with a modeled field elsewhere survives the round trip unchanged.
+var (
+	entityDetailsType = reflect.TypeFor[EntityDetails]()
+	entityVersionType = reflect.TypeFor[EntityVersion]()
+)
+
+// structType returns the struct type the elements of t are decoded into, looking through pointers, slices and
+// arrays, and false when t does not model them as a struct.
+func structType(t reflect.Type) (reflect.Type, bool) {
+	for t != nil && (t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	return t, t != nil && t.Kind() == reflect.Struct
+}
+
+// renameField returns the key of the field of struct type t stored under key, in YAML naming when toYAML is set
+// and in JSON naming otherwise, along with the type of the field.
+func renameField(t reflect.Type, key string, toYAML bool) (string, reflect.Type, bool) {
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		from, to := strings.ToLower(name), name
+		if toYAML {
+			from, to = to, from
+		}
+		if key == from {
+			return to, f.Type, true
+		}
+	}
+	return key, nil, false
+}
+
+// parseOrderedJSON decodes a JSON document into yaml.MapSlice objects, slices and scalars, preserving key order.
+// Numbers are kept as json.Number so they are written back exactly as the API sent them.
+func parseOrderedJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeOrderedValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after JSON document")
+	}
+	return v, nil
+}
+
+func decodeOrderedValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '{' {
+			return decodeOrderedObject(dec)
+		}
+		return decodeOrderedArray(dec)
+	}
+	return tok, nil
+}
+
+func decodeOrderedObject(dec *json.Decoder) (yaml.MapSlice, error) {
+	obj := yaml.MapSlice{}
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		val, err := decodeOrderedValue(dec)
+		if err != nil {
+			return nil, err
+		}
+		obj = append(obj, yaml.MapItem{Key: keyTok, Value: val})
+	}
+	_, err := dec.Token() // closing '}'
+	return obj, err
+}
+
+func decodeOrderedArray(dec *json.Decoder) ([]any, error) {
+	arr := []any{}
+	for dec.More() {
+		val, err := decodeOrderedValue(dec)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, val)
+	}
+	_, err := dec.Token() // closing ']'
+	return arr, err
+}
+
+// renameKeys returns a copy of v, decoded into type t, with the keys of the fields t models renamed. Values t
+// does not model keep every key as it is.
+func renameKeys(v any, t reflect.Type, toYAML bool) any {
+	switch val := v.(type) {
+	case yaml.MapSlice:
+		st, typed := structType(t)
+		out := make(yaml.MapSlice, 0, len(val))
+		for _, item := range val {
+			key, ft := fmt.Sprint(item.Key), reflect.Type(nil)
+			if typed {
+				key, ft, _ = renameField(st, key, toYAML)
+			}
+			out = append(out, yaml.MapItem{Key: key, Value: renameKeys(item.Value, ft, toYAML)})
+		}
+		return out
+	case []any:
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			t = t.Elem()
+		} else {
+			t = nil
+		}
+		out := make([]any, 0, len(val))
+		for _, item := range val {
+			out = append(out, renameKeys(item, t, toYAML))
+		}
+		return out
+	}
+	return v
+}
+
+// toYAMLKeys converts the API field names of a DescribeEntity Details document to the keys used in the YAML files.
+func toYAMLKeys(v any) any {
+	return renameKeys(v, entityDetailsType, true)
+}
+
+// toJSONKeys converts the keys of a Details document from the YAML files back to the API field names.
+func toJSONKeys(v any) any {
+	return renameKeys(v, entityDetailsType, false)
+}
+
+// marshalOrderedJSON encodes yaml.MapSlice values as JSON objects in their original key order.
+func marshalOrderedJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeOrderedJSON(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeOrderedJSON(buf *bytes.Buffer, v any) error {
+	switch t := v.(type) {
+	case yaml.MapSlice:
+		buf.WriteByte('{')
+		for i, item := range t {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(fmt.Sprint(item.Key))
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := writeOrderedJSON(buf, item.Value); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+		return nil
+	case []any:
+		buf.WriteByte('[')
+		for i, item := range t {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeOrderedJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+// mapSliceValue returns the value stored under key.
+func mapSliceValue(doc yaml.MapSlice, key string) (any, bool) {
+	for _, item := range doc {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// withoutKey returns a copy of doc without key.
+func withoutKey(doc yaml.MapSlice, key string) yaml.MapSlice {
+	out := make(yaml.MapSlice, 0, len(doc))
+	for _, item := range doc {
+		if fmt.Sprint(item.Key) != key {
+			out = append(out, item)
+		}
+	}
+	return out
+}
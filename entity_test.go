@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+	"gopkg.in/yaml.v2"
+)
+
+func TestOrderedJSONRoundTrip(t *testing.T) {
+	in := `{"Zeta":1,"Alpha":{"b":[1.5,"x",true,null],"a":{}},"Mid":[],"Big":12345678901234567890,"Price":1.10}`
+	v, err := parseOrderedJSON([]byte(in))
+	if err != nil {
+		t.Fatalf("parseOrderedJSON: %v", err)
+	}
+	out, err := marshalOrderedJSON(v)
+	if err != nil {
+		t.Fatalf("marshalOrderedJSON: %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("round trip = %s, want %s", out, in)
+	}
+
+	if _, err := parseOrderedJSON([]byte(`{"a":1} {"b":2}`)); err == nil {
+		t.Error("expected error for trailing data")
+	}
+	if _, err := parseOrderedJSON([]byte(`{"a":`)); err == nil {
+		t.Error("expected error for truncated JSON")
+	}
+}
+
+func TestYAMLKeyMapping(t *testing.T) {
+	doc := yaml.MapSlice{
+		{Key: "Description", Value: yaml.MapSlice{
+			{Key: "ProductTitle", Value: "Title"},
+			{Key: "NewAttribute", Value: "kept"},
+		}},
+		{Key: "PromotionalResources", Value: yaml.MapSlice{{Key: "LogoUrl", Value: "https://logo"}}},
+		{Key: "LegalTerms", Value: yaml.MapSlice{{Key: "Description", Value: "untyped"}}},
+		{Key: "SupportInformation", Value: yaml.MapSlice{
+			{Key: "Resources", Value: []any{yaml.MapSlice{{Key: "Type", Value: "Email"}}}},
+			{Key: "DESCRIPTION", Value: "other casing"},
+		}},
+	}
+	yamlDoc := toYAMLKeys(doc)
+	out, _ := marshalOrderedJSON(yamlDoc)
+	want := `{"description":{"producttitle":"Title","NewAttribute":"kept"},"promotionalresources":{"logourl":"https://logo"},` +
+		`"LegalTerms":{"Description":"untyped"},"supportinformation":{"resources":[{"Type":"Email"}],"DESCRIPTION":"other casing"}}`
+	if string(out) != want {
+		t.Errorf("yaml keys = %s, want %s", out, want)
+	}
+
+	back, _ := marshalOrderedJSON(toJSONKeys(yamlDoc))
+	wantBack := `{"Description":{"ProductTitle":"Title","NewAttribute":"kept"},"PromotionalResources":{"LogoUrl":"https://logo"},` +
+		`"LegalTerms":{"Description":"untyped"},"SupportInformation":{"Resources":[{"Type":"Email"}],"DESCRIPTION":"other casing"}}`
+	if string(back) != wantBack {
+		t.Errorf("json keys = %s, want %s", back, wantBack)
+	}
+}
+
+func TestDumpThenUpdateIsLossless(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	details := `{"Versions":[{"VersionTitle":"v1.0"}],` +
+		`"Description":{"ProductTitle":"Title","Highlights":["fast"],"Manufacturer":"ACME","Sku":"SKU-1"},` +
+		`"LegalTerms":{"Documents":[{"Type":"StandardEula","Url":"https://eula"}]},` +
+		`"PromotionalResources":{"PromotionalMedia":[{"Type":"Image","Url":"https://img"}]}}`
+	var sent string
+	svc := &mockMarketplaceClient{
+		listEntitiesFunc: func(_ context.Context, params *marketplacecatalog.ListEntitiesInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListEntitiesOutput, error) {
+			if *params.EntityType == productTypeContainer {
+				return makeListOutput(testProductName, "eid-1"), nil
+			}
+			return &marketplacecatalog.ListEntitiesOutput{}, nil
+		},
+		describeEntityFunc: func(_ context.Context, _ *marketplacecatalog.DescribeEntityInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeEntityOutput, error) {
+			return &marketplacecatalog.DescribeEntityOutput{Details: aws.String(details)}, nil
+		},
+		startChangeSetFunc: func(_ context.Context, params *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+			sent = *params.ChangeSet[0].Details
+			return &marketplacecatalog.StartChangeSetOutput{}, nil
+		},
+	}
+
+	if err := dumpProductWithClient(svc, testProductName); err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	dumped, _ := os.ReadFile(filepath.Join("data", testProductName, "description.yaml"))
+	for _, want := range []string{"producttitle: Title", "Manufacturer: ACME", "LegalTerms:", "promotionalmedia:"} {
+		if !strings.Contains(string(dumped), want) {
+			t.Errorf("description.yaml missing %q:\n%s", want, dumped)
+		}
+	}
+	if strings.Contains(string(dumped), "versions") {
+		t.Errorf("description.yaml should not contain versions:\n%s", dumped)
+	}
+
+	if err := updateProductWithClient(svc, testProductName, changeSetOptions{}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	want := `{"ProductTitle":"Title","Highlights":["fast"],"Manufacturer":"ACME","Sku":"SKU-1"}`
+	if sent != want {
+		t.Errorf("update sent %s, want %s", sent, want)
+	}
+}
@@ -8,6 +8,7 @@ require (
 	github.com/aws/aws-sdk-go-v2/service/marketplacecatalog v1.15.3
 	github.com/spf13/cobra v1.6.1
 	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
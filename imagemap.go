@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// imageMapping replaces the images of a version whose repository is name with uri.
+type imageMapping struct {
+	name string
+	uri  string
+}
+
+// imageRepository returns the repository of an image reference, without its registry, tag or digest.
+func imageRepository(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if colon := strings.LastIndex(image, ":"); colon > strings.LastIndex(image, "/") {
+		image = image[:colon]
+	}
+	if first, rest, ok := strings.Cut(image, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return rest
+	}
+	return image
+}
+
+// matches reports whether image belongs to the repository of the mapping, given either as the full repository
+// path or as its last segment.
+func (m imageMapping) matches(image string) bool {
+	repo := imageRepository(image)
+	return repo == m.name || path.Base(repo) == m.name
+}
+
+func parseImageMapping(entry string) (imageMapping, error) {
+	name, uri, ok := strings.Cut(entry, "=")
+	if !ok {
+		uri = entry
+		name = path.Base(imageRepository(uri))
+	}
+	name, uri = strings.TrimSpace(name), strings.TrimSpace(uri)
+	if name == "" || uri == "" {
+		return imageMapping{}, fmt.Errorf("invalid image mapping %q, expected name=uri", entry)
+	}
+	return imageMapping{name: name, uri: uri}, nil
+}
+
+// loadImagesFile reads a YAML file mapping repository names to image URIs.
+func loadImagesFile(file string) ([]imageMapping, error) {
+	data, err := os.ReadFile(file) //nolint:gosec // G304: the images file is a CLI flag value provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("failed to read images file: %w", err)
+	}
+	var images map[string]string
+	if err := yaml.Unmarshal(data, &images); err != nil {
+		return nil, fmt.Errorf("failed to parse images file %s: %w", file, err)
+	}
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	mappings := make([]imageMapping, 0, len(names))
+	for _, name := range names {
+		mapping, err := parseImageMapping(name + "=" + images[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// imageMappings returns the image mappings of a release: the --image name=uri flags followed by the images file.
+func (r releaseOptions) imageMappings() ([]imageMapping, error) {
+	var mappings []imageMapping
+	for _, entry := range r.images {
+		mapping, err := parseImageMapping(entry)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+	if r.imagesFile != "" {
+		fromFile, err := loadImagesFile(r.imagesFile)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, fromFile...)
+	}
+	if len(mappings) == 0 && (len(r.images) > 0 || r.imagesFile != "") {
+		return nil, errors.New("no image mappings given")
+	}
+	return mappings, nil
+}
+
+// mapImage returns the URI image is replaced with, failing when more than one mapping claims it.
+func mapImage(image string, mappings []imageMapping, used []bool) (string, bool, error) {
+	found := -1
+	for i, m := range mappings {
+		if !m.matches(image) {
+			continue
+		}
+		if found >= 0 {
+			return "", false, fmt.Errorf("image %s matches both %s and %s", image, mappings[found].name, m.name)
+		}
+		found = i
+	}
+	if found < 0 {
+		return "", false, nil
+	}
+	used[found] = true
+	return mappings[found].uri, true, nil
+}
+
+// mapVersionImages replaces every image of the image lists by repository name. Every image must be matched by a
+// mapping and every mapping must match an image, so nothing from the base version is kept or dropped by accident.
+func mapVersionImages(lists [][]string, mappings []imageMapping) ([][]string, error) {
+	used := make([]bool, len(mappings))
+	var unmatched []string
+	mapped := make([][]string, len(lists))
+	for i, images := range lists {
+		for _, image := range images {
+			uri, ok, err := mapImage(image, mappings, used)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				unmatched = append(unmatched, image)
+				uri = image
+			}
+			mapped[i] = append(mapped[i], uri)
+		}
+	}
+	if len(unmatched) > 0 {
+		return nil, fmt.Errorf("no image mapping for %s (map an image to its current URI to keep it)", strings.Join(unmatched, ", "))
+	}
+	for i, m := range mappings {
+		if !used[i] {
+			return nil, fmt.Errorf("image mapping %s=%s does not match any image of the base version", m.name, m.uri)
+		}
+	}
+	return mapped, nil
+}
+
+// setMappedVersionImages sets the release notes and title of a version document and replaces its images by
+// repository name.
+func setMappedVersionImages(doc *yamlDocument, version string, mappings []imageMapping, releaseNotes string) error {
+	if err := setReleaseFields(doc, version, releaseNotes); err != nil {
+		return err
+	}
+	lists := versionImageLists(doc)
+	images := make([][]string, len(lists))
+	for i, list := range lists {
+		images[i] = doc.stringItems(list)
+	}
+	mapped, err := mapVersionImages(images, mappings)
+	if err != nil {
+		return err
+	}
+	for i, list := range lists {
+		if list.node == nil {
+			continue
+		}
+		if err := doc.setStrings(list, mapped[i]); err != nil {
+			return fmt.Errorf("failed to update images: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestImageRepository(t *testing.T) {
+	tests := map[string]string{
+		"123456789012.dkr.ecr.us-east-1.amazonaws.com/team/app:1.0": "team/app",
+		"localhost:5000/app@sha256:abc":                             "app",
+		"localhost/app:1":                                           "app",
+		"library/nginx:1.25":                                        "library/nginx",
+		"nginx":                                                     "nginx",
+	}
+	for image, want := range tests {
+		if got := imageRepository(image); got != want {
+			t.Errorf("imageRepository(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestMapVersionImages(t *testing.T) {
+	base := [][]string{
+		{"123.dkr.ecr.us-east-1.amazonaws.com/team/app:1.0", "123.dkr.ecr.us-east-1.amazonaws.com/team/sidecar:1.0"},
+		{"123.dkr.ecr.us-east-1.amazonaws.com/team/migrate:1.0"},
+	}
+	mapping := func(entries ...string) []imageMapping {
+		t.Helper()
+		mappings, err := releaseOptions{images: entries}.imageMappings()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mappings
+	}
+
+	got, err := mapVersionImages(base, mapping("app=ecr/app:1.1", "team/sidecar=ecr/sidecar:1.1", "ecr/migrate:1.1"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := [][]string{{"ecr/app:1.1", "ecr/sidecar:1.1"}, {"ecr/migrate:1.1"}}
+	for i := range want {
+		if strings.Join(got[i], ",") != strings.Join(want[i], ",") {
+			t.Errorf("source %d images = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	errorCases := []struct {
+		name     string
+		mappings []imageMapping
+		wantErr  string
+	}{
+		{"image left untouched", mapping("app=ecr/app:1.1", "sidecar=ecr/sidecar:1.1"), "no image mapping for 123.dkr.ecr.us-east-1.amazonaws.com/team/migrate:1.0"},
+		{"unused mapping", mapping("app=a", "sidecar=b", "migrate=c", "worker=d"), "worker=d does not match"},
+		{"ambiguous mapping", mapping("app=a", "team/app=b", "sidecar=b", "migrate=c"), "matches both app and team/app"},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := mapVersionImages(base, tc.mappings)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+
+	if _, err := (releaseOptions{images: []string{"app="}}).imageMappings(); err == nil {
+		t.Error("expected error for a mapping without URI")
+	}
+}
+
+func TestReleaseWithImageMappings(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	dir := filepath.Join("data", "MyProduct", "versions")
+	_ = os.MkdirAll(dir, 0o755)
+	base := "versiontitle: v1.0\nreleasenotes: notes\nsources:\n- id: src-1\n  images:\n  - ecr/app:1.0 # main\n  - ecr/sidecar:1.0\n" +
+		"- id: src-2\n  images:\n  - ecr/migrate:1.0\n"
+	if err := os.WriteFile(filepath.Join(dir, "v1.0.yaml"), []byte(base), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	imagesFile := filepath.Join(tmpDir, "images.yaml")
+	if err := os.WriteFile(imagesFile, []byte("sidecar: ecr/sidecar:1.1\nmigrate: ecr/migrate:1.1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	details := makeEntityDetailsWithVersion(t, "v1.0")
+	svc := foundMock(t, "MyProduct", "eid-1", productTypeContainer, details)
+	release := releaseOptions{images: []string{"app=ecr/app:1.1"}, imagesFile: imagesFile}
+	if err := releaseVersionWithClient(svc, "MyProduct", "v1.1", "", "New", "v1.0", release, changeSetOptions{noOp: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "v1.1.yaml"))
+	want := strings.NewReplacer("v1.0", "v1.1", "releasenotes: notes", "releasenotes: New", ":1.0", ":1.1").Replace(base)
+	if string(got) != want {
+		t.Errorf("new version:\n%s\nwant:\n%s", got, want)
+	}
+
+	release = releaseOptions{images: []string{"app=ecr/app:1.2"}}
+	err := releaseVersionWithClient(svc, "MyProduct", "v1.2", "", "New", "v1.0", release, changeSetOptions{noOp: true})
+	if err == nil || !strings.Contains(err.Error(), "no image mapping for ecr/sidecar:1.0, ecr/migrate:1.0") {
+		t.Errorf("error = %v", err)
+	}
+
+	_, err = releaseArtifact("ecr/app:1.2", release)
+	if err == nil {
+		t.Error("expected error for a single image combined with mappings")
+	}
+}
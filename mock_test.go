@@ -2,16 +2,30 @@ package main
 
 import (
 	"context"
+	"strings"
+	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
 )
 
 const testProductName = "MyProduct"
 
+// withConfirmInput feeds input to confirmation prompts for the duration of the test.
+func withConfirmInput(t *testing.T, input string) {
+	t.Helper()
+	orig := confirmInput
+	confirmInput = strings.NewReader(input)
+	t.Cleanup(func() { confirmInput = orig })
+}
+
 type mockMarketplaceClient struct {
 	listEntitiesFunc   func(ctx context.Context, params *marketplacecatalog.ListEntitiesInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListEntitiesOutput, error)
 	describeEntityFunc func(ctx context.Context, params *marketplacecatalog.DescribeEntityInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeEntityOutput, error)
 	startChangeSetFunc func(ctx context.Context, params *marketplacecatalog.StartChangeSetInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error)
+
+	describeChangeSetFunc func(ctx context.Context, params *marketplacecatalog.DescribeChangeSetInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error)
+	listChangeSetsFunc    func(ctx context.Context, params *marketplacecatalog.ListChangeSetsInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListChangeSetsOutput, error)
+	cancelChangeSetFunc   func(ctx context.Context, params *marketplacecatalog.CancelChangeSetInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.CancelChangeSetOutput, error)
 }
 
 func (m *mockMarketplaceClient) ListEntities(ctx context.Context, params *marketplacecatalog.ListEntitiesInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListEntitiesOutput, error) {
@@ -25,3 +39,15 @@ func (m *mockMarketplaceClient) DescribeEntity(ctx context.Context, params *mark
 func (m *mockMarketplaceClient) StartChangeSet(ctx context.Context, params *marketplacecatalog.StartChangeSetInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
 	return m.startChangeSetFunc(ctx, params, optFns...)
 }
+
+func (m *mockMarketplaceClient) DescribeChangeSet(ctx context.Context, params *marketplacecatalog.DescribeChangeSetInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+	return m.describeChangeSetFunc(ctx, params, optFns...)
+}
+
+func (m *mockMarketplaceClient) ListChangeSets(ctx context.Context, params *marketplacecatalog.ListChangeSetsInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListChangeSetsOutput, error) {
+	return m.listChangeSetsFunc(ctx, params, optFns...)
+}
+
+func (m *mockMarketplaceClient) CancelChangeSet(ctx context.Context, params *marketplacecatalog.CancelChangeSetInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.CancelChangeSetOutput, error) {
+	return m.cancelChangeSetFunc(ctx, params, optFns...)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+)
+
+// localVersionTitles lists the versions that have a YAML file under data/<product>/versions.
+func localVersionTitles(productName string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join("data", productName, "versions"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var titles []string
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".yaml" {
+			continue
+		}
+		titles = append(titles, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	sort.Strings(titles)
+	return titles, nil
+}
+
+// unpublishedVersions returns the local version titles that do not exist on the live product yet.
+func unpublishedVersions(productName string, remote *EntityDetails) ([]string, error) {
+	local, err := localVersionTitles(productName)
+	if err != nil {
+		return nil, err
+	}
+	remoteTitles := make([]string, 0, len(remote.Versions))
+	for i := range remote.Versions {
+		remoteTitles = append(remoteTitles, remote.Versions[i].VersionTitle)
+	}
+	var missing []string
+	for _, title := range local {
+		if !slices.Contains(remoteTitles, title) {
+			missing = append(missing, title)
+		}
+	}
+	return missing, nil
+}
+
+// productPlan holds the pending changes between the local YAML files of a product and the live entity.
+type productPlan struct {
+	Description []fieldDiff
+	NewVersions []string
+}
+
+func (p *productPlan) changes() int {
+	return len(p.Description) + len(p.NewVersions)
+}
+
+// buildPlan compares the fields `update` sends and the version files `push-version` publishes with the live entity.
+func buildPlan(productName string, remote *EntityDetails) (*productPlan, error) {
+	local, err := loadLocalDescription(productName)
+	if err != nil {
+		return nil, fmt.Errorf("could not read local description: %w", err)
+	}
+	localDesc, _ := mapSliceValue(local, "description")
+	remoteDesc, _ := mapSliceValue(remote.descriptionDocument(), "description")
+	descDiffs, err := diffDocuments(
+		map[string]any{"description": localDesc},
+		map[string]any{"description": remoteDesc},
+	)
+	if err != nil {
+		return nil, err
+	}
+	newVersions, err := unpublishedVersions(productName, remote)
+	if err != nil {
+		return nil, fmt.Errorf("could not list local versions: %w", err)
+	}
+	return &productPlan{Description: descDiffs, NewVersions: newVersions}, nil
+}
+
+func printPlan(productName, entityID string, plan *productPlan, printer diffPrinter) {
+	fmt.Printf("Plan for product %s (entity ID %s):\n\n", productName, entityID)
+	if plan.changes() == 0 {
+		fmt.Println("No changes. The local YAML files match the live product.")
+		return
+	}
+	if len(plan.Description) > 0 {
+		printer.printDiffs(plan.Description)
+		fmt.Println()
+	}
+	if len(plan.NewVersions) > 0 {
+		fmt.Println("Versions not published yet:")
+		for _, v := range plan.NewVersions {
+			fmt.Println(printer.line("+", v))
+		}
+		fmt.Println()
+	}
+	fmt.Printf("Plan: %d change(s). Run update and push-version to apply them.\n", plan.changes())
+}
+
+// planWithClient prints the changes between the local YAML files and the live product, and reports whether there are any.
+func planWithClient(svc marketplaceClient, productName string, color bool) (bool, error) {
+	entityID, _, err := findProduct(svc, productName)
+	if err != nil {
+		return false, err
+	}
+	remote, err := describeProduct(svc, entityID)
+	if err != nil {
+		return false, err
+	}
+	plan, err := buildPlan(productName, remote)
+	if err != nil {
+		return false, err
+	}
+	printPlan(productName, entityID, plan, diffPrinter{color: color})
+	return plan.changes() > 0, nil
+}
+
+func planProduct(productName string, color bool) (bool, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return false, err
+	}
+	return planWithClient(marketplacecatalog.NewFromConfig(cfg), productName, color)
+}
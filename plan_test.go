@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// writeLocalDescription writes details as data/<product>/description.yaml in the current directory.
+func writeLocalDescription(t *testing.T, productName string, details *EntityDetails) {
+	t.Helper()
+	dir := filepath.Join("data", productName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	data, err := yaml.Marshal(details)
+	if err != nil {
+		t.Fatalf("yaml.Marshal: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "description.yaml"), data, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func makeEntityDetailsWithDescription(t *testing.T, title string, highlights ...string) *EntityDetails {
+	t.Helper()
+	var d EntityDetails
+	d.Description.ProductTitle = title
+	d.Description.Highlights = highlights
+	return &d
+}
+
+func TestPlanWithClient(t *testing.T) {
+	t.Run("no changes", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		remote := makeEntityDetailsWithDescription(t, "Title", "fast")
+		writeLocalDescription(t, testProductName, remote)
+		svc := foundMock(t, testProductName, "eid-1", productTypeContainer, remote)
+
+		changed, err := planWithClient(svc, testProductName, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if changed {
+			t.Error("expected no changes")
+		}
+	})
+
+	t.Run("description changes detected", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		writeLocalDescription(t, testProductName, makeEntityDetailsWithDescription(t, "Title", "fast", "cheap"))
+		svc := foundMock(t, testProductName, "eid-1", productTypeContainer, makeEntityDetailsWithDescription(t, "Title", "fast"))
+
+		changed, err := planWithClient(svc, testProductName, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !changed {
+			t.Error("expected changes")
+		}
+	})
+
+	t.Run("unpublished local version detected", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		remote := makeEntityDetailsWithVersion(t, "v1.0")
+		writeLocalDescription(t, testProductName, remote)
+		dir := filepath.Join("data", testProductName, "versions")
+		_ = os.MkdirAll(dir, 0o755)
+		_ = os.WriteFile(filepath.Join(dir, "v1.0.yaml"), []byte("versiontitle: v1.0\n"), 0o644)
+		_ = os.WriteFile(filepath.Join(dir, "v2.0.yaml"), []byte("versiontitle: v2.0\n"), 0o644)
+		svc := foundMock(t, testProductName, "eid-1", productTypeContainer, remote)
+
+		changed, err := planWithClient(svc, testProductName, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !changed {
+			t.Error("expected changes")
+		}
+
+		plan, err := buildPlan(testProductName, remote)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(plan.NewVersions) != 1 || plan.NewVersions[0] != "v2.0" {
+			t.Errorf("new versions = %v, want [v2.0]", plan.NewVersions)
+		}
+	})
+
+	t.Run("missing description file returns error", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		svc := foundMock(t, testProductName, "eid-1", productTypeContainer, &EntityDetails{})
+		if _, err := planWithClient(svc, testProductName, false); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+func TestUnpublishedVersionsWithoutVersionsDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	var remote EntityDetails
+	if err := json.Unmarshal([]byte(`{"Versions":[]}`), &remote); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	got, err := unpublishedVersions(testProductName, &remote)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %v, want none", got)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Media types of image configuration blobs, which record the platform of single-architecture images.
+var imageConfigMediaTypes = []string{"application/vnd.oci.image.config.v1+json", "application/vnd.docker.container.image.v1+json"}
+
+// fetchConfigPlatform reads the platform of a single-architecture image from its configuration blob.
+func (c *registryClient) fetchConfigPlatform(ref imageReference, digest string) (ociPlatform, error) {
+	resp, err := c.do(http.MethodGet, c.endpoint(ref, "blobs", digest), ref, imageConfigMediaTypes)
+	if err != nil {
+		return ociPlatform{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if err := registryStatus(resp, ref, "config "+digest); err != nil {
+		return ociPlatform{}, err
+	}
+	var platform ociPlatform
+	if err := json.NewDecoder(resp.Body).Decode(&platform); err != nil {
+		return ociPlatform{}, fmt.Errorf("failed to decode config %s of %s: %w", digest, ref.name, err)
+	}
+	return platform, nil
+}
+
+// imagePlatforms returns the platforms an image runs on: those listed in its index, leaving out attestations,
+// or the one of its configuration for a single-architecture image.
+func (c *registryClient) imagePlatforms(image string) ([]string, error) {
+	ref, manifest, err := c.resolveImage(image)
+	if err != nil {
+		return nil, err
+	}
+	if !manifest.isIndex() {
+		if manifest.Config == nil {
+			return nil, fmt.Errorf("manifest of %s has no config", image)
+		}
+		platform, err := c.fetchConfigPlatform(ref, manifest.Config.Digest)
+		if err != nil {
+			return nil, err
+		}
+		return []string{platform.String()}, nil
+	}
+	var platforms []string
+	for _, m := range manifest.Manifests {
+		if m.Platform != nil && m.Platform.OS != "unknown" {
+			platforms = append(platforms, m.Platform.String())
+		}
+	}
+	return platforms, nil
+}
+
+// hasPlatform reports whether platform is available. A platform declared without a variant, such as
+// linux/arm64, is satisfied by any variant of it.
+func hasPlatform(available []string, platform string) bool {
+	for _, p := range available {
+		if p == platform || strings.HasPrefix(p, platform+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePlatform(platform string) error {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return fmt.Errorf("invalid platform: %s. Platforms are written os/architecture, such as linux/arm64", platform)
+	}
+	return nil
+}
+
+// checkImagePlatforms prints which platforms an image is available for, reporting whether it has all of declared.
+func checkImagePlatforms(client *registryClient, image string, declared []string) (bool, error) {
+	available, err := client.imagePlatforms(image)
+	if err != nil {
+		return false, fmt.Errorf("failed to check the platforms of %s: %w", image, err)
+	}
+	var missing []string
+	for _, p := range declared {
+		if !hasPlatform(available, p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		fmt.Printf("  %s: %s, missing %s\n", image, strings.Join(available, ", "), strings.Join(missing, ", "))
+		return false, nil
+	}
+	fmt.Printf("  %s: %s OK\n", image, strings.Join(available, ", "))
+	return true, nil
+}
+
+// checkSourceImagePlatforms checks every image of a source against the platforms it declares, returning the
+// images that lack any of them. A source without platforms fails, as there is nothing to check its images against.
+func checkSourceImagePlatforms(client *registryClient, source Sources) ([]string, error) {
+	if len(source.Platforms) == 0 {
+		return nil, fmt.Errorf("source %s declares no platforms, list them under platforms to use --check-platforms", source.ID)
+	}
+	for _, p := range source.Platforms {
+		if err := validatePlatform(p); err != nil {
+			return nil, fmt.Errorf("source %s: %w", source.ID, err)
+		}
+	}
+	var failed []string
+	for _, image := range source.Images {
+		ok, err := checkImagePlatforms(client, image, source.Platforms)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			failed = append(failed, image)
+		}
+	}
+	return failed, nil
+}
+
+// checkSourcePlatforms makes sure the images of every container source are available for all the platforms the
+// source declares, printing a report per image, so a version never ships without an architecture buyers run.
+func checkSourcePlatforms(client *registryClient, sources []Sources) error {
+	fmt.Println("Checking image platforms:")
+	var failed []string
+	for _, source := range sources {
+		if len(source.Images) == 0 {
+			continue
+		}
+		images, err := checkSourceImagePlatforms(client, source)
+		if err != nil {
+			return err
+		}
+		failed = append(failed, images...)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("refusing to push, images missing declared platforms: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+	"gopkg.in/yaml.v2"
+)
+
+func TestHasPlatform(t *testing.T) {
+	available := []string{"linux/amd64", "linux/arm64/v8"}
+	for platform, want := range map[string]bool{
+		"linux/amd64":    true,
+		"linux/arm64":    true,
+		"linux/arm64/v8": true,
+		"linux/arm64/v7": false,
+		"linux/arm":      false,
+		"windows/amd64":  false,
+	} {
+		if got := hasPlatform(available, platform); got != want {
+			t.Errorf("hasPlatform(%q) = %v, want %v", platform, got, want)
+		}
+	}
+	for _, platform := range []string{"linux", "linux/", "/amd64", "linux/arm64/v8/extra"} {
+		if err := validatePlatform(platform); err == nil {
+			t.Errorf("validatePlatform(%q): expected error", platform)
+		}
+	}
+}
+
+func TestPushChecksPlatforms(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	registry := newFakeRegistry(t)
+	registry.pushMultiArch("app", "1.1")
+	registry.blobs["worker/sha256:amd64"] = `{"os":"linux","architecture":"amd64"}`
+	registry.push("worker", "1.1", platformManifest("amd64"))
+	host := registry.host()
+
+	platforms := []string{"linux/amd64", "linux/arm64"}
+	writeVersion := func(images ...string) {
+		t.Helper()
+		dir := filepath.Join("data", "MyProduct", "versions")
+		_ = os.MkdirAll(dir, 0o755)
+		data, _ := yaml.Marshal(YAMLVersionData{
+			Versiontitle:    "v1.1",
+			Releasenotes:    "notes",
+			Sources:         []Sources{{ID: "src-1", Images: images, Platforms: platforms}},
+			Deliveryoptions: []Deliveryoptions{{Title: "Option A"}},
+		})
+		if err := os.WriteFile(filepath.Join(dir, "v1.1.yaml"), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	started := false
+	svc := &mockMarketplaceClient{
+		listEntitiesFunc: func(_ context.Context, params *marketplacecatalog.ListEntitiesInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListEntitiesOutput, error) {
+			if *params.EntityType == productTypeContainer {
+				return makeListOutput("MyProduct", "eid-1"), nil
+			}
+			return &marketplacecatalog.ListEntitiesOutput{}, nil
+		},
+		startChangeSetFunc: func(context.Context, *marketplacecatalog.StartChangeSetInput, ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+			started = true
+			return nil, nil
+		},
+	}
+	opts := changeSetOptions{registry: registryOptions{checkPlatforms: true, insecure: true}}
+
+	writeVersion(host+"/app:1.1", host+"/worker:1.1")
+	err := pushNewVersionWithClient(svc, "MyProduct", "v1.1", opts)
+	if err == nil || !strings.Contains(err.Error(), "missing declared platforms: "+host+"/worker:1.1") {
+		t.Errorf("error = %v", err)
+	}
+	if started {
+		t.Error("a version missing a platform should not be submitted")
+	}
+
+	writeVersion(host + "/app:1.1")
+	opts.noOp = true
+	if err := pushNewVersionWithClient(svc, "MyProduct", "v1.1", opts); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	writeVersion(host + "/app:1.2")
+	if err := pushNewVersionWithClient(svc, "MyProduct", "v1.1", opts); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("error for a missing image = %v", err)
+	}
+
+	platforms = nil
+	writeVersion(host + "/app:1.1")
+	if err := pushNewVersionWithClient(svc, "MyProduct", "v1.1", opts); err == nil || !strings.Contains(err.Error(), "source src-1 declares no platforms") {
+		t.Errorf("error for a source without platforms = %v", err)
+	}
+}
@@ -25,45 +25,71 @@ type marketplaceClient interface {
 	ListEntities(ctx context.Context, params *marketplacecatalog.ListEntitiesInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListEntitiesOutput, error)
 	DescribeEntity(ctx context.Context, params *marketplacecatalog.DescribeEntityInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeEntityOutput, error)
 	StartChangeSet(ctx context.Context, params *marketplacecatalog.StartChangeSetInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error)
+	DescribeChangeSet(ctx context.Context, params *marketplacecatalog.DescribeChangeSetInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error)
+	ListChangeSets(ctx context.Context, params *marketplacecatalog.ListChangeSetsInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListChangeSetsOutput, error)
+	CancelChangeSet(ctx context.Context, params *marketplacecatalog.CancelChangeSetInput, optFns ...func(*marketplacecatalog.Options)) (*marketplacecatalog.CancelChangeSetOutput, error)
 }
 
+// EntityVersion is a published version of a product, as returned in the DescribeEntity Details.
+type EntityVersion struct {
+	ID                  string    `json:"Id"`
+	ReleaseNotes        string    `json:"ReleaseNotes"`
+	UpgradeInstructions string    `json:"UpgradeInstructions"`
+	VersionTitle        string    `json:"VersionTitle"`
+	CreationDate        time.Time `json:"CreationDate"`
+	Sources             []struct {
+		Type               string   `json:"Type"`
+		ID                 string   `json:"Id"`
+		Images             []string `json:"Images"`
+		Image              string   `json:"Image" yaml:",omitempty"`
+		Architecture       string   `json:"Architecture" yaml:",omitempty"`
+		VirtualizationType string   `json:"VirtualizationType" yaml:",omitempty"`
+		OperatingSystem    struct {
+			Name         string `json:"Name"`
+			Version      string `json:"Version"`
+			Username     string `json:"Username"`
+			ScanningPort int    `json:"ScanningPort"`
+		} `json:"OperatingSystem" yaml:",omitempty"`
+		Compatibility struct {
+			Platform string `json:"Platform"`
+		} `json:"Compatibility"`
+	} `json:"Sources"`
+	DeliveryOptions []struct {
+		ID               string `json:"Id"`
+		Type             string `json:"Type"`
+		SourceID         string `json:"SourceId"`
+		Title            string `json:"Title"`
+		ShortDescription string `json:"ShortDescription"`
+		IsRecommended    bool   `json:"isRecommended"`
+		Compatibility    struct {
+			AWSServices []string `json:"AWSServices"`
+		} `json:"Compatibility"`
+		Instructions struct {
+			Usage string `json:"Usage"`
+		} `json:"Instructions"`
+		Recommendations struct {
+			DeploymentResources []struct {
+				Text string `json:"Text"`
+				URL  string `json:"Url"`
+			} `json:"DeploymentResources"`
+			InstanceType   string `json:"InstanceType" yaml:",omitempty"`
+			SecurityGroups []struct {
+				Protocol string   `json:"Protocol"`
+				FromPort int      `json:"FromPort"`
+				ToPort   int      `json:"ToPort"`
+				CidrIps  []string `json:"CidrIps"`
+			} `json:"SecurityGroups" yaml:",omitempty"`
+		} `json:"Recommendations"`
+		Visibility string `json:"Visibility"`
+	} `json:"DeliveryOptions"`
+}
+
+// EntityDetails is the typed view of the DescribeEntity Details payload. raw holds the complete payload, including
+// fields this struct does not model, so it can be written back without losing them.
 type EntityDetails struct {
-	Versions []struct {
-		ID                  string    `json:"Id"`
-		ReleaseNotes        string    `json:"ReleaseNotes"`
-		UpgradeInstructions string    `json:"UpgradeInstructions"`
-		VersionTitle        string    `json:"VersionTitle"`
-		CreationDate        time.Time `json:"CreationDate"`
-		Sources             []struct {
-			Type          string   `json:"Type"`
-			ID            string   `json:"Id"`
-			Images        []string `json:"Images"`
-			Compatibility struct {
-				Platform string `json:"Platform"`
-			} `json:"Compatibility"`
-		} `json:"Sources"`
-		DeliveryOptions []struct {
-			ID               string `json:"Id"`
-			Type             string `json:"Type"`
-			SourceID         string `json:"SourceId"`
-			Title            string `json:"Title"`
-			ShortDescription string `json:"ShortDescription"`
-			IsRecommended    bool   `json:"isRecommended"`
-			Compatibility    struct {
-				AWSServices []string `json:"AWSServices"`
-			} `json:"Compatibility"`
-			Instructions struct {
-				Usage string `json:"Usage"`
-			} `json:"Instructions"`
-			Recommendations struct {
-				DeploymentResources []struct {
-					Text string `json:"Text"`
-					URL  string `json:"Url"`
-				} `json:"DeploymentResources"`
-			} `json:"Recommendations"`
-			Visibility string `json:"Visibility"`
-		} `json:"DeliveryOptions"`
-	} `json:"Versions"`
+	raw yaml.MapSlice
+
+	Versions    []EntityVersion `json:"Versions"`
 	Description struct {
 		Highlights       []string `json:"Highlights"`
 		LongDescription  string   `json:"LongDescription"`
@@ -303,9 +329,36 @@ func describeProduct(svc marketplaceClient, entityID string) (*EntityDetails, er
 	if err := json.Unmarshal([]byte(*resp.Details), &details); err != nil {
 		return nil, err
 	}
+	raw, err := parseOrderedJSON([]byte(*resp.Details))
+	if err != nil {
+		return nil, err
+	}
+	details.raw, _ = raw.(yaml.MapSlice)
 	return &details, nil
 }
 
+// versionDocument returns a version as written to its YAML file: its complete payload with the keys EntityVersion
+// models lowercased and the settings of each delivery option in the section of its type, so dumped versions can
+// be released and compared as they are. Details built without a payload fall back to the typed version.
+func (d *EntityDetails) versionDocument(version *EntityVersion) any {
+	versions, _ := mapSliceValue(d.raw, "Versions")
+	items, _ := versions.([]any)
+	for _, item := range items {
+		doc, _ := item.(yaml.MapSlice)
+		if title, _ := mapSliceValue(doc, "VersionTitle"); title == version.VersionTitle {
+			renamed, _ := renameKeys(doc, entityVersionType, true).(yaml.MapSlice)
+			return nestVersionDeliveryDetails(renamed)
+		}
+	}
+	return version
+}
+
+// descriptionDocument returns the entity without its versions, with the keys used in description.yaml.
+func (d *EntityDetails) descriptionDocument() yaml.MapSlice {
+	doc, _ := toYAMLKeys(withoutKey(d.raw, "Versions")).(yaml.MapSlice)
+	return doc
+}
+
 func latestVersion(details *EntityDetails) (string, error) {
 	if len(details.Versions) == 0 {
 		return "", errors.New("product has no versions")
@@ -356,13 +409,11 @@ func dumpProductWithClient(svc marketplaceClient, productName string) error {
 	if err != nil {
 		return err
 	}
-	details.Versions = nil
-
 	fileName, err := getYamlFilePath(productName, "", "description")
 	if err != nil {
 		return err
 	}
-	data, err := yaml.Marshal(details)
+	data, err := yaml.Marshal(details.descriptionDocument())
 	if err != nil {
 		return err
 	}
@@ -380,26 +431,40 @@ func dumpProduct(productName string) error {
 	return dumpProductWithClient(marketplacecatalog.NewFromConfig(cfg), productName)
 }
 
-func updateProductWithClient(svc marketplaceClient, productName string, noOp bool) error {
-	entityID, foundType, err := findProduct(svc, productName)
+// loadLocalDescription reads the product's description.yaml as written by dump, keeping key order and unknown fields.
+func loadLocalDescription(productName string) (yaml.MapSlice, error) {
+	descPath, err := getYamlFilePath(productName, "", "description")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	data, err := os.ReadFile(descPath) //nolint:gosec // G304: path is constructed internally from product name, not raw user input
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
 
-	descPath, err := getYamlFilePath(productName, "", "description")
+func updateProductWithClient(svc marketplaceClient, productName string, opts changeSetOptions) error {
+	entityID, foundType, err := findProduct(svc, productName)
 	if err != nil {
 		return err
 	}
-	data, err := os.ReadFile(descPath) //nolint:gosec // G304: path is constructed internally from product name, not raw user input
+
+	doc, err := loadLocalDescription(productName)
 	if err != nil {
 		return err
 	}
-	var details EntityDetails
-	if err := yaml.Unmarshal(data, &details); err != nil {
-		return err
+	if _, ok := mapSliceValue(doc, "description"); !ok {
+		return fmt.Errorf("no description section in the description.yaml of product %s", productName)
 	}
+	jsonDoc, _ := toJSONKeys(doc).(yaml.MapSlice)
+	description, _ := mapSliceValue(jsonDoc, "Description")
 
-	detailsBytes, err := json.Marshal(details.Description)
+	detailsBytes, err := marshalOrderedJSON(description)
 	if err != nil {
 		return err
 	}
@@ -421,25 +486,33 @@ func updateProductWithClient(svc marketplaceClient, productName string, noOp boo
 		ChangeSetName: aws.String("Updated product Information for " + productName),
 	}
 
-	if noOp {
+	token, err := changeSetRequestToken(productName, changeSetInput.ChangeSet, opts.requestToken)
+	if err != nil {
+		return err
+	}
+	changeSetInput.ClientRequestToken = aws.String(token)
+
+	if opts.noOp {
 		changeSetJSON, _ := json.MarshalIndent(changeSetInput, "", "  ")
 		fmt.Println(string(changeSetJSON))
 		return nil
 	}
 
-	_, err = svc.StartChangeSet(context.Background(), changeSetInput)
+	ctx, cancel := waitContext(opts)
+	defer cancel()
+	changeSetID, err := submitChangeSet(ctx, svc, changeSetInput, entityID, opts)
 	if err != nil {
-		return errors.New("could not start change set: " + err.Error())
+		return fmt.Errorf("could not start change set: %w", err)
 	}
 
 	fmt.Printf("Changeset created for product %s (%s) with entity ID %s\n", productName, foundType, entityID)
-	return nil
+	return awaitChangeSet(ctx, svc, changeSetID, opts)
 }
 
-func updateProduct(productName string, noOp bool) error {
+func updateProduct(productName string, opts changeSetOptions) error {
 	cfg, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
 		return errors.New("couldn't load default config")
 	}
-	return updateProductWithClient(marketplacecatalog.NewFromConfig(cfg), productName, noOp)
+	return updateProductWithClient(marketplacecatalog.NewFromConfig(cfg), productName, opts)
 }
@@ -55,6 +55,22 @@ func foundMock(t *testing.T, productName, entityID, productType string, details
 	}
 }
 
+// payloadMock returns a mock that finds productName under productType and describes it with a raw DescribeEntity
+// payload, for details the typed EntityDetails does not model.
+func payloadMock(productName, entityID, productType, payload string) *mockMarketplaceClient {
+	return &mockMarketplaceClient{
+		listEntitiesFunc: func(_ context.Context, params *marketplacecatalog.ListEntitiesInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListEntitiesOutput, error) {
+			if *params.EntityType == productType {
+				return makeListOutput(productName, entityID), nil
+			}
+			return &marketplacecatalog.ListEntitiesOutput{}, nil
+		},
+		describeEntityFunc: func(context.Context, *marketplacecatalog.DescribeEntityInput, ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeEntityOutput, error) {
+			return &marketplacecatalog.DescribeEntityOutput{Details: aws.String(payload)}, nil
+		},
+	}
+}
+
 // makeEntityDetailsWithVersion builds an EntityDetails containing a single version via JSON.
 func makeEntityDetailsWithVersion(t *testing.T, versionTitle string) *EntityDetails {
 	t.Helper()
@@ -600,7 +616,7 @@ func TestUpdateProductWithClient(t *testing.T) {
 
 		setupDescriptionFile(t)
 		svc := &mockMarketplaceClient{listEntitiesFunc: listFuncFoundAs(productTypeContainer)}
-		if err := updateProductWithClient(svc, "MyProduct", true); err != nil {
+		if err := updateProductWithClient(svc, "MyProduct", changeSetOptions{noOp: true}); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 	})
@@ -620,7 +636,7 @@ func TestUpdateProductWithClient(t *testing.T) {
 				return &marketplacecatalog.StartChangeSetOutput{}, nil
 			},
 		}
-		if err := updateProductWithClient(svc, "MyProduct", false); err != nil {
+		if err := updateProductWithClient(svc, "MyProduct", changeSetOptions{}); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 		if !called {
@@ -635,7 +651,7 @@ func TestUpdateProductWithClient(t *testing.T) {
 		defer func() { _ = os.Chdir(origDir) }()
 
 		svc := &mockMarketplaceClient{listEntitiesFunc: listFuncFoundAs(productTypeContainer)}
-		if err := updateProductWithClient(svc, "MyProduct", false); err == nil {
+		if err := updateProductWithClient(svc, "MyProduct", changeSetOptions{}); err == nil {
 			t.Fatal("expected error")
 		}
 	})
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Manifest media types of the OCI and Docker registry APIs.
+const (
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+var manifestMediaTypes = []string{mediaTypeOCIIndex, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeDockerManifest}
+
+// maxManifestSize bounds how much of a manifest is read, well above what registries accept.
+const maxManifestSize = 4 << 20
+
+// registryHTTPClient sends the requests to container registries.
+var registryHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// registryOptions holds how images are looked up in their registries and which checks use them.
+type registryOptions struct {
+	pinDigests     bool
+	checkPlatforms bool
+	insecure       bool
+	username       string
+	password       string
+	token          string
+}
+
+// imageReference is an image URI split into the parts the registry API addresses.
+type imageReference struct {
+	name       string
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+// parseImageReference splits an image URI such as registry/repo:tag or registry/repo@sha256:... Images without
+// a registry host are looked up on Docker Hub.
+func parseImageReference(image string) (imageReference, error) {
+	ref := imageReference{}
+	ref.name, ref.digest, _ = strings.Cut(image, "@")
+	if colon := strings.LastIndex(ref.name, ":"); colon > strings.LastIndex(ref.name, "/") {
+		ref.name, ref.tag = ref.name[:colon], ref.name[colon+1:]
+	}
+	if ref.tag == "" && ref.digest == "" {
+		return imageReference{}, fmt.Errorf("image %s has no tag or digest", image)
+	}
+	ref.registry, ref.repository = "registry-1.docker.io", ref.name
+	if first, rest, ok := strings.Cut(ref.name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		ref.registry, ref.repository = first, rest
+	} else if !ok {
+		ref.repository = "library/" + ref.name
+	}
+	if ref.repository == "" {
+		return imageReference{}, fmt.Errorf("image %s has no repository", image)
+	}
+	return ref, nil
+}
+
+// reference is the tag or digest the manifest of the image is fetched by, preferring the digest.
+func (r imageReference) reference() string {
+	if r.digest != "" {
+		return r.digest
+	}
+	return r.tag
+}
+
+// manifestDescriptor is an entry of an image index or manifest list.
+type manifestDescriptor struct {
+	MediaType string       `json:"mediaType"`
+	Digest    string       `json:"digest"`
+	Platform  *ociPlatform `json:"platform,omitempty"`
+}
+
+type ociPlatform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+func (p ociPlatform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// imageManifest holds the fields of an image manifest or index the release checks.
+type imageManifest struct {
+	MediaType string               `json:"mediaType"`
+	Manifests []manifestDescriptor `json:"manifests"`
+	Config    *manifestDescriptor  `json:"config,omitempty"`
+	digest    string
+}
+
+func (m imageManifest) isIndex() bool {
+	return m.MediaType == mediaTypeOCIIndex || m.MediaType == mediaTypeDockerManifestList
+}
+
+// registryClient talks to the OCI Distribution API of container registries, authenticating with a static token,
+// basic credentials, or bearer tokens obtained for the challenges the registry sends.
+type registryClient struct {
+	opts   registryOptions
+	tokens map[string]string
+}
+
+func newRegistryClient(opts registryOptions) *registryClient {
+	return &registryClient{opts: opts, tokens: map[string]string{}}
+}
+
+func (c *registryClient) endpoint(ref imageReference, kind, reference string) string {
+	scheme := "https"
+	if c.opts.insecure {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", scheme, ref.registry, ref.repository, kind, reference)
+}
+
+func (c *registryClient) authorize(req *http.Request, ref imageReference) {
+	switch token := c.tokens[ref.registry+"/"+ref.repository]; {
+	case c.opts.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.opts.token)
+	case token != "":
+		req.Header.Set("Authorization", "Bearer "+token)
+	case c.opts.username != "":
+		req.SetBasicAuth(c.opts.username, c.opts.password)
+	}
+}
+
+// do sends a request to the registry of ref, answering an authentication challenge once.
+func (c *registryClient) do(method, endpoint string, ref imageReference, accept []string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+		c.authorize(req, ref)
+		resp, err := registryHTTPClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("registry %s: %w", ref.registry, err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("Www-Authenticate")
+		_ = resp.Body.Close()
+		if err := c.authenticate(ref, challenge); err != nil {
+			return nil, err
+		}
+	}
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authenticate answers a WWW-Authenticate challenge: bearer challenges get a token from the registry's token
+// service, basic ones are retried with the configured credentials.
+func (c *registryClient) authenticate(ref imageReference, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch {
+	case c.opts.token != "":
+		return fmt.Errorf("registry %s rejected the registry token", ref.registry)
+	case strings.EqualFold(scheme, "bearer"):
+		return c.fetchToken(ref, params)
+	case c.opts.username == "":
+		return fmt.Errorf("registry %s requires authentication, pass --registry-username and --registry-password or --registry-token", ref.registry)
+	}
+	return fmt.Errorf("registry %s rejected the credentials of %s", ref.registry, c.opts.username)
+}
+
+// tokenURL returns the URL of a pull token for the repository of ref, from the parameters of a bearer challenge.
+func tokenURL(ref imageReference, params string) (string, error) {
+	values := map[string]string{}
+	for _, m := range challengeParam.FindAllStringSubmatch(params, -1) {
+		values[strings.ToLower(m[1])] = m[2]
+	}
+	realm, err := url.Parse(values["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("registry %s sent an invalid token realm %q", ref.registry, values["realm"])
+	}
+	query := realm.Query()
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	query.Set("scope", "repository:"+ref.repository+":pull")
+	realm.RawQuery = query.Encode()
+	return realm.String(), nil
+}
+
+// fetchToken gets a pull token for the repository of ref from the token service of a bearer challenge.
+func (c *registryClient) fetchToken(ref imageReference, params string) error {
+	endpoint, err := tokenURL(ref, params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if c.opts.username != "" {
+		req.SetBasicAuth(c.opts.username, c.opts.password)
+	}
+	resp, err := registryHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get a token for %s: %w", ref.name, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get a token for %s: token service returned %s", ref.name, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode token for %s: %w", ref.name, err)
+	}
+	c.tokens[ref.registry+"/"+ref.repository] = cmp.Or(token.Token, token.AccessToken)
+	return nil
+}
+
+// fetchManifest gets the manifest of ref at reference, a tag or digest, and records its digest. A manifest
+// fetched by digest must hash to that digest.
+func (c *registryClient) fetchManifest(ref imageReference, reference string) (imageManifest, error) {
+	resp, err := c.do(http.MethodGet, c.endpoint(ref, "manifests", reference), ref, manifestMediaTypes)
+	if err != nil {
+		return imageManifest{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if err := registryStatus(resp, ref, "manifest "+reference); err != nil {
+		return imageManifest{}, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+	if err != nil {
+		return imageManifest{}, fmt.Errorf("failed to read manifest %s of %s: %w", reference, ref.name, err)
+	}
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if strings.HasPrefix(reference, "sha256:") && reference != digest {
+		return imageManifest{}, fmt.Errorf("manifest %s of %s has digest %s", reference, ref.name, digest)
+	}
+	var manifest imageManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return imageManifest{}, fmt.Errorf("failed to decode manifest %s of %s: %w", reference, ref.name, err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType, _, _ = strings.Cut(resp.Header.Get("Content-Type"), ";")
+	}
+	manifest.digest = digest
+	return manifest, nil
+}
+
+// registryStatus turns an unsuccessful registry response about object, such as "manifest 1.0", into an error.
+func registryStatus(resp *http.Response, ref imageReference, object string) error {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("%s of %s not found in registry %s", object, ref.name, ref.registry)
+	}
+	return fmt.Errorf("registry %s returned %s for %s of %s", ref.registry, resp.Status, object, ref.name)
+}
+
+// checkManifest makes sure the registry has the manifest with digest, such as one platform of an index.
+func (c *registryClient) checkManifest(ref imageReference, digest string) error {
+	resp, err := c.do(http.MethodHead, c.endpoint(ref, "manifests", digest), ref, manifestMediaTypes)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return registryStatus(resp, ref, "manifest "+digest)
+}
+
+// resolveImage fetches the manifest an image URI points at. For a multi-architecture image every platform
+// manifest of its index must be present too.
+func (c *registryClient) resolveImage(image string) (imageReference, imageManifest, error) {
+	ref, err := parseImageReference(image)
+	if err != nil {
+		return imageReference{}, imageManifest{}, err
+	}
+	manifest, err := c.fetchManifest(ref, ref.reference())
+	if err != nil {
+		return imageReference{}, imageManifest{}, err
+	}
+	if !manifest.isIndex() {
+		return ref, manifest, nil
+	}
+	if len(manifest.Manifests) == 0 {
+		return imageReference{}, imageManifest{}, fmt.Errorf("image index of %s lists no manifests", image)
+	}
+	for _, m := range manifest.Manifests {
+		if err := c.checkManifest(ref, m.Digest); err != nil {
+			return imageReference{}, imageManifest{}, err
+		}
+	}
+	return ref, manifest, nil
+}
+
+// pinImage returns the image URI pointing at the digest of its tag instead of the tag.
+func (c *registryClient) pinImage(image string) (string, error) {
+	ref, manifest, err := c.resolveImage(image)
+	if err != nil {
+		return "", err
+	}
+	if ref.digest != "" && ref.digest != manifest.digest {
+		return "", fmt.Errorf("image %s resolved to digest %s", image, manifest.digest)
+	}
+	return ref.name + "@" + manifest.digest, nil
+}
+
+// pinReleaseImages resolves every image a release publishes to its digest, returning the pinned URI of each.
+func pinReleaseImages(image string, release releaseOptions, registry registryOptions) (map[string]string, error) {
+	if !registry.pinDigests {
+		return nil, nil
+	}
+	if release.ami != "" {
+		return nil, errors.New("--pin-digests only applies to image releases")
+	}
+	uris := []string{image}
+	if release.mapsImages() {
+		mappings, err := release.imageMappings()
+		if err != nil {
+			return nil, err
+		}
+		uris = uris[:0]
+		for _, m := range mappings {
+			uris = append(uris, m.uri)
+		}
+	}
+	client := newRegistryClient(registry)
+	pinned := map[string]string{}
+	for _, uri := range uris {
+		if _, ok := pinned[uri]; ok {
+			continue
+		}
+		digestURI, err := client.pinImage(uri)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pin %s: %w", uri, err)
+		}
+		fmt.Printf("Pinned %s to %s\n", uri, digestURI)
+		pinned[uri] = digestURI
+	}
+	return pinned, nil
+}
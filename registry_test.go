@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRegistry serves manifests over the OCI Distribution API, optionally behind basic or bearer authentication.
+type fakeRegistry struct {
+	manifests map[string]string // "repo/reference" to manifest JSON
+	blobs     map[string]string // "repo/digest" to blob content
+	username  string
+	password  string
+	token     string
+	server    *httptest.Server
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	t.Helper()
+	r := &fakeRegistry{manifests: map[string]string{}, blobs: map[string]string{}}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+// push stores a manifest under its digest and, when given, a tag, returning the digest.
+func (r *fakeRegistry) push(repo, tag, manifest string) string {
+	sum := sha256.Sum256([]byte(manifest))
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	r.manifests[repo+"/"+digest] = manifest
+	if tag != "" {
+		r.manifests[repo+"/"+tag] = manifest
+	}
+	return digest
+}
+
+func (r *fakeRegistry) authorized(req *http.Request) bool {
+	user, pass, ok := req.BasicAuth()
+	switch {
+	case r.token != "":
+		return req.Header.Get("Authorization") == "Bearer "+r.token
+	case r.username != "":
+		return ok && user == r.username && pass == r.password
+	}
+	return true
+}
+
+func (r *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if user, pass, _ := req.BasicAuth(); user != r.username || pass != r.password || req.URL.Query().Get("scope") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"token":"` + r.token + `"}`))
+		return
+	}
+	if !r.authorized(req) {
+		if r.token != "" {
+			w.Header().Set("Www-Authenticate", `Bearer realm="`+r.server.URL+`/token",service="fake"`)
+		} else {
+			w.Header().Set("Www-Authenticate", `Basic realm="fake"`)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	objects := r.manifests
+	repo, reference, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/"), "/manifests/")
+	if !ok {
+		objects = r.blobs
+		repo, reference, _ = strings.Cut(strings.TrimPrefix(req.URL.Path, "/v2/"), "/blobs/")
+	}
+	manifest, ok := objects[repo+"/"+reference]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_, _ = w.Write([]byte(manifest))
+}
+
+func platformManifest(arch string) string {
+	return `{"mediaType":"` + mediaTypeOCIManifest + `","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:` + arch + `"}}`
+}
+
+func indexManifest(digests map[string]string) string {
+	var entries []string
+	for _, arch := range []string{"amd64", "arm64"} {
+		if digest, ok := digests[arch]; ok {
+			entries = append(entries, `{"mediaType":"`+mediaTypeOCIManifest+`","digest":"`+digest+`","platform":{"os":"linux","architecture":"`+arch+`"}}`)
+		}
+	}
+	return `{"mediaType":"` + mediaTypeOCIIndex + `","manifests":[` + strings.Join(entries, ",") + `]}`
+}
+
+// pushMultiArch pushes an amd64 and arm64 image under tag, returning the digest of its index.
+func (r *fakeRegistry) pushMultiArch(repo, tag string) string {
+	digests := map[string]string{
+		"amd64": r.push(repo, "", platformManifest("amd64")),
+		"arm64": r.push(repo, "", platformManifest("arm64")),
+	}
+	return r.push(repo, tag, indexManifest(digests))
+}
+
+func TestParseImageReference(t *testing.T) {
+	tests := []struct {
+		image string
+		want  imageReference
+	}{
+		{"123.dkr.ecr.us-east-1.amazonaws.com/team/app:1.0", imageReference{name: "123.dkr.ecr.us-east-1.amazonaws.com/team/app", registry: "123.dkr.ecr.us-east-1.amazonaws.com", repository: "team/app", tag: "1.0"}},
+		{"localhost:5000/app@sha256:abc", imageReference{name: "localhost:5000/app", registry: "localhost:5000", repository: "app", digest: "sha256:abc"}},
+		{"nginx:1.25", imageReference{name: "nginx", registry: "registry-1.docker.io", repository: "library/nginx", tag: "1.25"}},
+		{"bitnami/redis:7", imageReference{name: "bitnami/redis", registry: "registry-1.docker.io", repository: "bitnami/redis", tag: "7"}},
+	}
+	for _, tc := range tests {
+		got, err := parseImageReference(tc.image)
+		if err != nil || got != tc.want {
+			t.Errorf("parseImageReference(%q) = %+v, %v, want %+v", tc.image, got, err, tc.want)
+		}
+	}
+	if _, err := parseImageReference("registry.example.com/app"); err == nil {
+		t.Error("expected error for an image without tag or digest")
+	}
+}
+
+func TestPinImage(t *testing.T) {
+	registry := newFakeRegistry(t)
+	digest := registry.pushMultiArch("team/app", "1.1")
+	single := registry.push("team/app", "single", platformManifest("amd64"))
+	registry.push("team/app", "broken", indexManifest(map[string]string{"amd64": "sha256:missing"}))
+	client := newRegistryClient(registryOptions{insecure: true})
+	image := registry.host() + "/team/app"
+
+	tests := []struct {
+		image   string
+		want    string
+		wantErr string
+	}{
+		{image + ":1.1", image + "@" + digest, ""},
+		{image + ":single", image + "@" + single, ""},
+		{image + "@" + digest, image + "@" + digest, ""},
+		{image + ":1.2", "", "manifest 1.2 of " + image + " not found"},
+		{image + ":broken", "", "manifest sha256:missing of " + image + " not found"},
+	}
+	for _, tc := range tests {
+		got, err := client.pinImage(tc.image)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("pinImage(%q) error = %v, want %q", tc.image, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("pinImage(%q) = %q, %v, want %q", tc.image, got, err, tc.want)
+		}
+	}
+}
+
+func TestPinImageAuthentication(t *testing.T) {
+	registry := newFakeRegistry(t)
+	digest := registry.push("app", "1.1", platformManifest("amd64"))
+	image := registry.host() + "/app:1.1"
+	want := registry.host() + "/app@" + digest
+
+	registry.username, registry.password = "user", "secret"
+	if _, err := newRegistryClient(registryOptions{insecure: true}).pinImage(image); err == nil || !strings.Contains(err.Error(), "requires authentication") {
+		t.Errorf("error without credentials = %v", err)
+	}
+	if _, err := newRegistryClient(registryOptions{insecure: true, username: "user", password: "wrong"}).pinImage(image); err == nil || !strings.Contains(err.Error(), "rejected the credentials") {
+		t.Errorf("error with wrong password = %v", err)
+	}
+	basic := registryOptions{insecure: true, username: "user", password: "secret"}
+	if got, err := newRegistryClient(basic).pinImage(image); err != nil || got != want {
+		t.Errorf("basic auth = %q, %v, want %q", got, err, want)
+	}
+
+	registry.token = "pull-token"
+	if got, err := newRegistryClient(basic).pinImage(image); err != nil || got != want {
+		t.Errorf("token auth = %q, %v, want %q", got, err, want)
+	}
+	if got, err := newRegistryClient(registryOptions{insecure: true, token: "pull-token"}).pinImage(image); err != nil || got != want {
+		t.Errorf("static token = %q, %v, want %q", got, err, want)
+	}
+	if _, err := newRegistryClient(registryOptions{insecure: true, token: "stale"}).pinImage(image); err == nil {
+		t.Error("expected error for a rejected token")
+	}
+}
+
+func TestReleasePinsDigests(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	registry := newFakeRegistry(t)
+	appDigest := registry.pushMultiArch("app", "1.1")
+	sidecarDigest := registry.pushMultiArch("sidecar", "1.1")
+	host := registry.host()
+
+	dir := filepath.Join("data", "MyProduct", "versions")
+	_ = os.MkdirAll(dir, 0o755)
+	base := "versiontitle: v1.0\nreleasenotes: notes\nsources:\n- id: src-1\n  images:\n  - " + host + "/app:1.0\n  - " + host + "/sidecar:1.0\n"
+	if err := os.WriteFile(filepath.Join(dir, "v1.0.yaml"), []byte(base), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	details := makeEntityDetailsWithVersion(t, "v1.0")
+	svc := foundMock(t, "MyProduct", "eid-1", productTypeContainer, details)
+	release := releaseOptions{images: []string{"app=" + host + "/app:1.1", "sidecar=" + host + "/sidecar:1.1"}}
+	opts := changeSetOptions{noOp: true, registry: registryOptions{pinDigests: true, insecure: true}}
+	if err := releaseVersionWithClient(svc, "MyProduct", "v1.1", "", "New", "v1.0", release, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := os.ReadFile(filepath.Join(dir, "v1.1.yaml"))
+	for _, want := range []string{host + "/app@" + appDigest, host + "/sidecar@" + sidecarDigest} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("new version does not reference %s:\n%s", want, got)
+		}
+	}
+
+	err := releaseVersionWithClient(svc, "MyProduct", "v1.2", host+"/app:1.2", "New", "v1.0", releaseOptions{}, opts)
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("error for a missing tag = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "v1.2.yaml")); !os.IsNotExist(err) {
+		t.Error("a release failing to pin its images should not write the new version")
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
@@ -18,113 +21,565 @@ func validateReleaseParams(productName, newVersion, image, releaseNotes string)
 	return nil
 }
 
-func resolveBaseVersion(details *EntityDetails, baseVersion string) (string, error) {
+// resolveBaseVersion returns the version a release is cloned from: the explicit base version, or the remote
+// version with the highest semantic version on the release channel that matches the base constraint.
+func resolveBaseVersion(details *EntityDetails, baseVersion string, release releaseOptions) (string, error) {
 	if baseVersion != "" {
+		if release.baseConstraint != "" || release.channel != "" {
+			return "", errors.New("--base-version cannot be combined with --base-constraint or --channel")
+		}
 		return baseVersion, nil
 	}
-	bv, err := latestVersion(details)
+	bv, reason, err := selectBaseVersion(details, release)
 	if err != nil {
 		return "", fmt.Errorf("could not auto-detect base version: %w", err)
 	}
-	fmt.Printf("Auto-detected base version: %s\n", bv)
+	fmt.Printf("Auto-detected base version: %s (%s)\n", bv, reason)
 	return bv, nil
 }
 
-// writeBaseVersionYAML marshals and persists the named base version from details to disk.
-func writeBaseVersionYAML(productName, baseVersion string, details *EntityDetails) error {
+// onChannel reports whether v is a release, or with a channel, a prerelease such as 2.0.0-beta.1 on it.
+func onChannel(v semVersion, channel string) bool {
+	if channel == "" {
+		return len(v.prerelease) == 0
+	}
+	return len(v.prerelease) > 0 && v.prerelease[0] == channel
+}
+
+// highestMatching returns the title of the highest remote version on channel that satisfies constraint.
+func highestMatching(details *EntityDetails, constraint semverConstraint, channel string) string {
+	best := ""
 	for i := range details.Versions {
-		if details.Versions[i].VersionTitle != baseVersion {
+		title := details.Versions[i].VersionTitle
+		v, ok := parseSemver(title)
+		if !ok || !onChannel(v, channel) || !constraint.matches(v) {
 			continue
 		}
-		data, err := yaml.Marshal(details.Versions[i])
-		if err != nil {
-			return fmt.Errorf("failed to marshal base version: %w", err)
+		if best == "" || compareVersionTitles(title, best) > 0 {
+			best = title
+		}
+	}
+	return best
+}
+
+// selectBaseVersion picks the base version by semantic version precedence, so a hotfix on an older line does not
+// become the base of the next release. Products without semantic version titles fall back to the newest version.
+func selectBaseVersion(details *EntityDetails, release releaseOptions) (version, reason string, err error) {
+	constraint, err := parseSemverConstraint(release.baseConstraint)
+	if err != nil {
+		return "", "", err
+	}
+	reason = "highest semantic version"
+	if release.baseConstraint != "" {
+		reason += " matching " + release.baseConstraint
+	}
+	if release.channel != "" {
+		reason += " on the " + release.channel + " channel"
+	}
+	if best := highestMatching(details, constraint, release.channel); best != "" {
+		return best, reason, nil
+	}
+	if release.baseConstraint != "" || release.channel != "" {
+		return "", "", fmt.Errorf("no version is the %s", reason)
+	}
+	version, err = latestVersion(details)
+	return version, "newest version, no release has a semantic version title", err
+}
+
+// versionImageLists returns the images list of every source in a version document, indexed like the sources.
+// Sources without an images key are returned with a nil node.
+func versionImageLists(doc *yamlDocument) []yamlValue {
+	sources, ok := doc.field(doc.top(), "sources")
+	if !ok {
+		return nil
+	}
+	var lists []yamlValue
+	for _, source := range doc.items(sources) {
+		images, _ := doc.field(source, "images")
+		lists = append(lists, images)
+	}
+	return lists
+}
+
+// syncBaseVersionYAML updates the release notes, title, images and AMI of an existing base version file from the
+// live version. Everything else in the file, including comments, is left as the team wrote it.
+func syncBaseVersionYAML(src []byte, version *EntityVersion) ([]byte, error) {
+	doc, err := parseYAMLDocument(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := setReleaseFields(doc, version.VersionTitle, version.ReleaseNotes); err != nil {
+		return nil, err
+	}
+	sources, _ := doc.field(doc.top(), "sources")
+	for i, source := range doc.items(sources) {
+		if i >= len(version.Sources) {
+			break
+		}
+		if images, ok := doc.field(source, "images"); ok {
+			if err := doc.setStrings(images, version.Sources[i].Images); err != nil {
+				return nil, err
+			}
+		}
+		if image, ok := doc.field(source, "image"); ok && version.Sources[i].Image != "" {
+			if err := doc.setString(image, version.Sources[i].Image); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc.bytes(), nil
+}
+
+// baseVersionYAML returns the path of the named base version file and its content refreshed from details. An
+// existing file only gets the fields a release rewrites refreshed, so its comments and layout carry over to the
+// cloned version.
+func baseVersionYAML(productName, baseVersion string, details *EntityDetails) (string, []byte, error) {
+	for i := range details.Versions {
+		version := &details.Versions[i]
+		if version.VersionTitle != baseVersion {
+			continue
 		}
 		filePath, err := getYamlFilePath(productName, "versions", baseVersion)
 		if err != nil {
-			return err
+			return "", nil, err
 		}
-		if err := os.WriteFile(filePath, data, 0o644); err != nil { //nolint:gosec // G306: 0644 is intentional — user-readable YAML version files
-			return fmt.Errorf("failed to write base version YAML: %w", err)
+		existing, err := os.ReadFile(filePath) //nolint:gosec // G304: path is constructed internally, not from raw user input
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			data, err := yaml.Marshal(details.versionDocument(version))
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to marshal base version: %w", err)
+			}
+			return filePath, data, nil
+		case err != nil:
+			return "", nil, fmt.Errorf("failed to read base version YAML: %w", err)
 		}
-		fmt.Printf("Base version written to %s\n", filePath)
-		return nil
+		data, err := syncBaseVersionYAML(existing, version)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to update base version YAML %s: %w", filePath, err)
+		}
+		return filePath, data, nil
 	}
-	return fmt.Errorf("base version %q not found in product versions", baseVersion)
+	return "", nil, fmt.Errorf("base version %q not found in product versions", baseVersion)
 }
 
-func releaseVersionWithClient(svc marketplaceClient, productName, newVersion, image, releaseNotes, baseVersion string, noOp bool) error {
-	if err := validateReleaseParams(productName, newVersion, image, releaseNotes); err != nil {
+// writeBaseVersionYAML persists the named base version from details to disk.
+func writeBaseVersionYAML(productName, baseVersion string, details *EntityDetails) error {
+	filePath, data, err := baseVersionYAML(productName, baseVersion, details)
+	if err != nil {
 		return err
 	}
+	return writeFileIfChanged(filePath, data,
+		"Base version "+baseVersion+" at "+filePath+" is up to date",
+		"Base version written to "+filePath,
+	)
+}
+
+// releaseOptions holds the optional settings of a release.
+type releaseOptions struct {
+	addOnVersion   string
+	ami            string
+	bump           string
+	baseConstraint string
+	channel        string
+	images         []string
+	imagesFile     string
+	notes          releaseNotesOptions
+	pinned         map[string]string
+}
+
+// pinnedImage returns the digest URI an image was pinned to, or the image itself when it was not pinned.
+func (r releaseOptions) pinnedImage(image string) string {
+	if pinned, ok := r.pinned[image]; ok {
+		return pinned
+	}
+	return image
+}
+
+// requestedVersion returns what the caller asked the new version to be: an explicit title, or a --bump level.
+func requestedVersion(newVersion string, release releaseOptions) (string, error) {
+	if release.bump == "" {
+		return newVersion, nil
+	}
+	if newVersion != "" {
+		return "", errors.New("pass either a new version or --bump, not both")
+	}
+	if !slices.Contains(semverBumps, release.bump) {
+		return "", fmt.Errorf("invalid bump: %s. Valid bumps are: %s", release.bump, strings.Join(semverBumps, ", "))
+	}
+	return release.bump, nil
+}
+
+// latestSemverVersion returns the remote version with the highest semantic version, ignoring titles that are
+// not semantic versions.
+func latestSemverVersion(details *EntityDetails) (string, bool) {
+	latest := ""
+	for i := range details.Versions {
+		title := details.Versions[i].VersionTitle
+		if _, ok := parseSemver(title); ok && (latest == "" || compareVersionTitles(title, latest) > 0) {
+			latest = title
+		}
+	}
+	return latest, latest != ""
+}
 
-	entityID, _, err := findProduct(svc, productName)
+// releaseLineVersion returns the highest remote version of the line a release continues. With --base-constraint or
+// --channel that is the highest version they match, so a release on an older line is not held against a newer major.
+func releaseLineVersion(details *EntityDetails, release releaseOptions) (string, bool, error) {
+	if release.baseConstraint == "" && release.channel == "" {
+		latest, ok := latestSemverVersion(details)
+		return latest, ok, nil
+	}
+	constraint, err := parseSemverConstraint(release.baseConstraint)
 	if err != nil {
-		return err
+		return "", false, err
 	}
+	latest := highestMatching(details, constraint, release.channel)
+	return latest, latest != "", nil
+}
 
-	details, err := describeProduct(svc, entityID)
+// bumpVersion returns latest bumped by the --bump level, failing when there is no semantic version to bump.
+func bumpVersion(latest, bump string) (string, error) {
+	current, ok := parseSemver(latest)
+	if !ok {
+		return "", errors.New("--bump needs a remote version whose title is a semantic version, pass the new version instead")
+	}
+	next, err := bumpSemver(current, bump)
 	if err != nil {
-		return err
+		return "", err
 	}
+	fmt.Printf("Bumped %s version %s to %s\n", bump, latest, next)
+	return next.String(), nil
+}
 
-	baseVersion, err = resolveBaseVersion(details, baseVersion)
+// nextReleaseVersion returns the title of the version to release, computing it from the latest remote version of
+// the release line for --bump. The title must not exist yet and, when it is a semantic version, must be newer than
+// the latest version of the line.
+func nextReleaseVersion(details *EntityDetails, newVersion string, release releaseOptions) (string, error) {
+	latest, hasLatest, err := releaseLineVersion(details, release)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if release.bump != "" {
+		if newVersion, err = bumpVersion(latest, release.bump); err != nil {
+			return "", err
+		}
+	}
+	for i := range details.Versions {
+		if details.Versions[i].VersionTitle == newVersion {
+			return "", fmt.Errorf("version %s already exists on the product", newVersion)
+		}
+	}
+	if _, ok := parseSemver(newVersion); ok && hasLatest && compareVersionTitles(newVersion, latest) <= 0 {
+		return "", fmt.Errorf("version %s is not newer than the latest version %s", newVersion, latest)
+	}
+	return newVersion, nil
+}
+
+// mapsImages reports whether the release replaces images by repository name instead of with a single image.
+func (r releaseOptions) mapsImages() bool {
+	return len(r.images) > 0 || r.imagesFile != ""
+}
+
+// releaseArtifact returns what the release publishes: the AMI ID for server products, the image or the image
+// mappings otherwise.
+func releaseArtifact(image string, release releaseOptions) (string, error) {
+	switch {
+	case release.ami != "" && (image != "" || release.mapsImages()):
+		return "", errors.New("--image and --ami cannot be combined")
+	case release.ami != "":
+		return release.ami, validateAmiID(release.ami)
+	case image != "" && release.mapsImages():
+		return "", errors.New("a single image cannot be combined with image mappings")
+	case release.mapsImages():
+		mappings, err := release.imageMappings()
+		if err != nil {
+			return "", err
+		}
+		uris := make([]string, 0, len(mappings))
+		for _, m := range mappings {
+			uris = append(uris, m.uri)
+		}
+		return strings.Join(uris, ", "), nil
 	}
+	return image, nil
+}
+
+// checkReleaseProductType makes sure AMI releases target server products and image releases everything else.
+func checkReleaseProductType(productName, productType string, release releaseOptions) error {
+	switch {
+	case release.ami != "" && productType != productTypeServer:
+		return fmt.Errorf("--ami can only be used with %s products, %s is a %s", productTypeServer, productName, productType)
+	case release.ami == "" && productType == productTypeServer:
+		return fmt.Errorf("%s is a %s, release it with --ami instead of --image", productName, productType)
+	}
+	return nil
+}
 
-	if err := writeBaseVersionYAML(productName, baseVersion, details); err != nil {
+// setReleasedImages replaces the images of the cloned version, by repository name when the release maps them.
+func setReleasedImages(doc *yamlDocument, newVersion, image, releaseNotes string, release releaseOptions) error {
+	if !release.mapsImages() {
+		return setVersionImages(doc, newVersion, release.pinnedImage(image), releaseNotes)
+	}
+	mappings, err := release.imageMappings()
+	if err != nil {
 		return err
 	}
+	for i := range mappings {
+		mappings[i].uri = release.pinnedImage(mappings[i].uri)
+	}
+	return setMappedVersionImages(doc, newVersion, mappings, releaseNotes)
+}
 
-	if err := cloneProductVersion(productName, baseVersion, newVersion); err != nil {
-		return fmt.Errorf("failed to clone version: %w", err)
+// setReleasedVersion points the cloned version document at the released artifact.
+func setReleasedVersion(doc *yamlDocument, newVersion, image, releaseNotes string, release releaseOptions) error {
+	if release.ami != "" {
+		if err := setAMIVersion(doc, newVersion, release.ami, releaseNotes); err != nil {
+			return fmt.Errorf("failed to update version YAML: %w", err)
+		}
+		return nil
 	}
 
-	if err := updateVersionYAML(productName, newVersion, image, releaseNotes); err != nil {
+	if err := setReleasedImages(doc, newVersion, image, releaseNotes, release); err != nil {
 		return fmt.Errorf("failed to update version YAML: %w", err)
 	}
 
-	return pushNewVersionWithClient(svc, productName, noOp, newVersion)
+	if release.addOnVersion != "" {
+		if err := setAddOnVersions(doc, release.addOnVersion); err != nil {
+			return fmt.Errorf("failed to update EKS add-on version: %w", err)
+		}
+	}
+	return nil
+}
+
+// releasePlan is a release checked against the live product, ready to be written to disk and pushed.
+type releasePlan struct {
+	productName  string
+	productType  string
+	details      *EntityDetails
+	newVersion   string
+	baseVersion  string
+	image        string
+	releaseNotes string
+	release      releaseOptions
+	baseFile     string
+	baseData     []byte
+	versionFile  string
+	versionData  []byte
+}
+
+// buildReleaseVersion clones the base version of a plan in memory, points the clone at the released artifact and
+// checks it converts to the delivery options of the product, so nothing is written for a release that cannot
+// be pushed.
+func buildReleaseVersion(plan releasePlan) (releasePlan, error) {
+	var err error
+	plan.baseFile, plan.baseData, err = baseVersionYAML(plan.productName, plan.baseVersion, plan.details)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	cloned, err := cloneVersionYAML(plan.baseData, plan.baseVersion, plan.newVersion, false)
+	if err != nil {
+		return releasePlan{}, fmt.Errorf("failed to clone version: %w", err)
+	}
+	doc, err := parseYAMLDocument(cloned)
+	if err != nil {
+		return releasePlan{}, fmt.Errorf("failed to clone version: %w", err)
+	}
+	if err := setReleasedVersion(doc, plan.newVersion, plan.image, plan.releaseNotes, plan.release); err != nil {
+		return releasePlan{}, err
+	}
+	plan.versionData = doc.bytes()
+	var version YAMLVersionData
+	if err := yaml.Unmarshal(plan.versionData, &version); err != nil {
+		return releasePlan{}, fmt.Errorf("failed to parse version %s: %w", plan.newVersion, err)
+	}
+	if err := checkDeliveryOptionTypes(plan.productType, version.Deliveryoptions); err != nil {
+		return releasePlan{}, err
+	}
+	if _, err := version.convertToDst(); err != nil {
+		return releasePlan{}, fmt.Errorf("could not convert version %s: %w", plan.newVersion, err)
+	}
+	plan.versionFile, err = getYamlFilePath(plan.productName, "versions", plan.newVersion)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	return plan, nil
+}
+
+// planReleaseWithClient validates a release and resolves its new and base versions without changing anything.
+func planReleaseWithClient(svc marketplaceClient, productName, newVersion, image, releaseNotes, baseVersion string, release releaseOptions, opts changeSetOptions) (releasePlan, error) {
+	artifact, err := releaseArtifact(image, release)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	requested, err := requestedVersion(newVersion, release)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	if err := checkReleaseNotesSources(release.notes); err != nil {
+		return releasePlan{}, err
+	}
+	if err := validateReleaseParams(productName, requested, artifact, cmp.Or(releaseNotes, release.notes.source())); err != nil {
+		return releasePlan{}, err
+	}
+	release.pinned, err = pinReleaseImages(image, release, opts.registry)
+	if err != nil {
+		return releasePlan{}, err
+	}
+
+	entityID, foundType, err := findProduct(svc, productName)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	if err := checkReleaseProductType(productName, foundType, release); err != nil {
+		return releasePlan{}, err
+	}
+
+	details, err := describeProduct(svc, entityID)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	plan, err := resolveReleaseVersions(releasePlan{
+		productName:  productName,
+		productType:  foundType,
+		details:      details,
+		newVersion:   newVersion,
+		baseVersion:  baseVersion,
+		image:        image,
+		releaseNotes: releaseNotes,
+		release:      release,
+	})
+	if err != nil {
+		return releasePlan{}, err
+	}
+	return buildReleaseVersion(plan)
+}
+
+// resolveReleaseVersions fills in the title of the new version and the version it is cloned from. Release notes
+// given as a source are only loaded then, so a changelog section can be picked for a bumped version.
+func resolveReleaseVersions(plan releasePlan) (releasePlan, error) {
+	var err error
+	plan.newVersion, err = nextReleaseVersion(plan.details, plan.newVersion, plan.release)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	plan.baseVersion, err = resolveBaseVersion(plan.details, plan.baseVersion, plan.release)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	if plan.release.notes.source() != "" {
+		plan.releaseNotes, err = loadReleaseNotes(plan.release.notes, plan.newVersion)
+		if err != nil {
+			return releasePlan{}, err
+		}
+	}
+	return plan, nil
+}
+
+// runRelease writes the base and new version files of a plan and pushes the new version.
+func runRelease(svc marketplaceClient, plan releasePlan, opts changeSetOptions) error {
+	if err := writeFileIfChanged(plan.baseFile, plan.baseData,
+		"Base version "+plan.baseVersion+" at "+plan.baseFile+" is up to date",
+		"Base version written to "+plan.baseFile,
+	); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(plan.versionFile, plan.versionData, 0o644); err != nil { //nolint:gosec // G306: 0644 is intentional — user-readable YAML version files
+		return fmt.Errorf("failed to write version YAML: %w", err)
+	}
+	fmt.Printf("Data written to %s\n", plan.versionFile)
+
+	return pushNewVersionWithClient(svc, plan.productName, plan.newVersion, opts)
 }
 
-func releaseVersion(productName, newVersion, image, releaseNotes, baseVersion string, noOp bool) error {
+func releaseVersionWithClient(svc marketplaceClient, productName, newVersion, image, releaseNotes, baseVersion string, release releaseOptions, opts changeSetOptions) error {
+	plan, err := planReleaseWithClient(svc, productName, newVersion, image, releaseNotes, baseVersion, release, opts)
+	if err != nil {
+		return err
+	}
+	return runRelease(svc, plan, opts)
+}
+
+func releaseVersion(productName, newVersion, image, releaseNotes, baseVersion string, release releaseOptions, opts changeSetOptions) error {
 	cfg, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
 		return fmt.Errorf("couldn't load AWS config: %w", err)
 	}
-	return releaseVersionWithClient(marketplacecatalog.NewFromConfig(cfg), productName, newVersion, image, releaseNotes, baseVersion, noOp)
+	return releaseVersionWithClient(marketplacecatalog.NewFromConfig(cfg), productName, newVersion, image, releaseNotes, baseVersion, release, opts)
 }
 
-func updateVersionYAML(productName, version, image, releaseNotes string) error {
+// editVersionYAML applies edit to the YAML file of a version and writes it back. Only the values edit touches change,
+// so comments and the order of keys are preserved.
+func editVersionYAML(productName, version string, edit func(doc *yamlDocument) error) error {
 	filePath, err := getYamlFilePath(productName, "versions", version)
 	if err != nil {
 		return err
 	}
 
-	data, err := getYAMLData(filePath)
+	src, err := os.ReadFile(filePath) //nolint:gosec // G304: path is constructed internally, not from raw user input
 	if err != nil {
 		return fmt.Errorf("failed to read version YAML: %w", err)
 	}
 
-	data.Releasenotes = releaseNotes
-	data.Versiontitle = version
-
-	for i := range data.Sources {
-		for j := range data.Sources[i].Images {
-			data.Sources[i].Images[j] = image
-		}
+	doc, err := parseYAMLDocument(src)
+	if err != nil {
+		return fmt.Errorf("failed to parse version YAML %s: %w", filePath, err)
 	}
 
-	yamlBytes, err := yaml.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("failed to marshal updated YAML: %w", err)
+	if err := edit(doc); err != nil {
+		return err
 	}
 
-	if err := os.WriteFile(filePath, yamlBytes, 0o644); err != nil { //nolint:gosec // G306: 0644 is intentional — user-readable YAML version files
+	if err := os.WriteFile(filePath, doc.bytes(), 0o644); err != nil { //nolint:gosec // G306: 0644 is intentional — user-readable YAML version files
 		return fmt.Errorf("failed to write updated YAML: %w", err)
 	}
 
 	fmt.Printf("Updated version YAML at %s\n", filePath)
 	return nil
 }
+
+func setReleaseFields(doc *yamlDocument, version, releaseNotes string) error {
+	if err := doc.setTopLevelString("releasenotes", releaseNotes); err != nil {
+		return fmt.Errorf("failed to update release notes: %w", err)
+	}
+	if err := doc.setTopLevelString("versiontitle", version); err != nil {
+		return fmt.Errorf("failed to update version title: %w", err)
+	}
+	return nil
+}
+
+// setAMIVersion sets the release notes, title and AMI of a server product version document.
+func setAMIVersion(doc *yamlDocument, version, ami, releaseNotes string) error {
+	if err := setReleaseFields(doc, version, releaseNotes); err != nil {
+		return err
+	}
+	return setVersionAMI(doc, ami)
+}
+
+// setVersionImages sets the release notes, title and images of a version document.
+func setVersionImages(doc *yamlDocument, version, image, releaseNotes string) error {
+	if err := setReleaseFields(doc, version, releaseNotes); err != nil {
+		return err
+	}
+	for _, list := range versionImageLists(doc) {
+		if list.node == nil {
+			continue
+		}
+		images := doc.stringItems(list)
+		for i := range images {
+			images[i] = image
+		}
+		if err := doc.setStrings(list, images); err != nil {
+			return fmt.Errorf("failed to update images: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateVersionYAML sets the release notes, title and images of a version file.
+func updateVersionYAML(productName, version, image, releaseNotes string) error {
+	return editVersionYAML(productName, version, func(doc *yamlDocument) error {
+		return setVersionImages(doc, version, image, releaseNotes)
+	})
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
@@ -36,7 +38,7 @@ func TestValidateReleaseParams(t *testing.T) {
 
 func TestResolveBaseVersion(t *testing.T) {
 	t.Run("explicit base version returned as-is", func(t *testing.T) {
-		got, err := resolveBaseVersion(&EntityDetails{}, "v1.0")
+		got, err := resolveBaseVersion(&EntityDetails{}, "v1.0", releaseOptions{})
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -47,7 +49,7 @@ func TestResolveBaseVersion(t *testing.T) {
 
 	t.Run("auto-detects latest when empty", func(t *testing.T) {
 		details := makeEntityDetailsWithVersion(t, "v2.0")
-		got, err := resolveBaseVersion(details, "")
+		got, err := resolveBaseVersion(details, "", releaseOptions{})
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -57,7 +59,7 @@ func TestResolveBaseVersion(t *testing.T) {
 	})
 
 	t.Run("no versions returns error when base is empty", func(t *testing.T) {
-		_, err := resolveBaseVersion(&EntityDetails{}, "")
+		_, err := resolveBaseVersion(&EntityDetails{}, "", releaseOptions{})
 		if err == nil {
 			t.Fatal("expected error")
 		}
@@ -80,6 +82,31 @@ func TestWriteBaseVersionYAML(t *testing.T) {
 		}
 	})
 
+	t.Run("existing file keeps comments and only refreshes release fields", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		dir := filepath.Join("data", "MyProduct", "versions")
+		_ = os.MkdirAll(dir, 0o755)
+		path := filepath.Join(dir, "v1.0.yaml")
+		local := "# hand-written comment\nversiontitle: v1.0\nreleasenotes: stale notes\nid: local-id # kept\n"
+		if err := os.WriteFile(path, []byte(local), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		details := makeEntityDetailsWithVersion(t, "v1.0")
+		if err := writeBaseVersionYAML("MyProduct", "v1.0", details); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got, _ := os.ReadFile(path)
+		want := "# hand-written comment\nversiontitle: v1.0\nreleasenotes: notes\nid: local-id # kept\n"
+		if string(got) != want {
+			t.Errorf("base version file = %q, want %q", got, want)
+		}
+	})
+
 	t.Run("version not found returns error", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		origDir, _ := os.Getwd()
@@ -116,7 +143,7 @@ func TestReleaseVersionWithClient(t *testing.T) {
 		details := makeEntityDetailsWithVersion(t, "v1.0")
 		svc := foundMock(t, "MyProduct", "eid-1", productTypeContainer, details)
 
-		err := releaseVersionWithClient(svc, "MyProduct", "v2.0", "ecr:v2", "Release notes", "v1.0", true)
+		err := releaseVersionWithClient(svc, "MyProduct", "v2.0", "ecr:v2", "Release notes", "v1.0", releaseOptions{}, changeSetOptions{noOp: true})
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -129,9 +156,72 @@ func TestReleaseVersionWithClient(t *testing.T) {
 		}
 	})
 
+	t.Run("addon version updates EKS add-on options", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		dir := filepath.Join("data", "MyProduct", "versions")
+		_ = os.MkdirAll(dir, 0o755)
+		base := "versiontitle: v1.0\nreleasenotes: notes\nsources:\n- id: src-1\n  images:\n  - ecr:v1\n" +
+			"deliveryoptions:\n- type: ECR\n  sourceid: src-1\n- type: EksAddOn\n  sourceid: src-1\n  eksaddon:\n" +
+			"    name: agent\n    version: v1.0.0-eksbuild.1\n    kubernetesversions:\n    - \"1.30\"\n"
+		if err := os.WriteFile(filepath.Join(dir, "v1.0.yaml"), []byte(base), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		details := makeEntityDetailsWithVersion(t, "v1.0")
+		svc := foundMock(t, "MyProduct", "eid-1", productTypeContainer, details)
+		err := releaseVersionWithClient(svc, "MyProduct", "v2.0", "ecr:v2", "Release notes", "v1.0",
+			releaseOptions{addOnVersion: "v2.0.0-eksbuild.1"}, changeSetOptions{noOp: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, err := getYAMLData(filepath.Join(dir, "v2.0.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := data.Deliveryoptions[1].Eksaddon.Version; got != "v2.0.0-eksbuild.1" {
+			t.Errorf("add-on version = %q", got)
+		}
+		if got := data.Sources[0].Images[0]; got != "ecr:v2" {
+			t.Errorf("image = %q", got)
+		}
+	})
+
+	t.Run("addon version updates a dumped EKS add-on option", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		payload := `{"Versions":[{"Id":"vid-1","VersionTitle":"v1.0","ReleaseNotes":"notes","CreationDate":"2024-01-01T00:00:00Z",` +
+			`"Sources":[{"Type":"DockerImages","Id":"src-1","Images":["ecr:v1"]}],` +
+			`"DeliveryOptions":[{"Id":"do-1","Type":"EksAddOn","SourceId":"src-1","Title":"Add-on",` +
+			`"AddOnName":"agent","AddOnVersion":"v1.0.0-eksbuild.1","SupportedKubernetesVersions":["1.30"]}]}]}`
+		svc := payloadMock("MyProduct", "eid-1", productTypeContainer, payload)
+		if err := dumpVersionsWithClient(svc, "MyProduct"); err != nil {
+			t.Fatal(err)
+		}
+		err := releaseVersionWithClient(svc, "MyProduct", "v2.0", "ecr:v2", "Release notes", "v1.0",
+			releaseOptions{addOnVersion: "v2.0.0-eksbuild.1"}, changeSetOptions{noOp: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, err := getYAMLData(filepath.Join("data", "MyProduct", "versions", "v2.0.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		addOn := data.Deliveryoptions[0].Eksaddon
+		if addOn.Name != "agent" || addOn.Version != "v2.0.0-eksbuild.1" || len(addOn.Kubernetesversions) != 1 {
+			t.Errorf("eksaddon = %+v", addOn)
+		}
+	})
+
 	t.Run("validation failure returns error immediately", func(t *testing.T) {
 		svc := foundMock(t, "MyProduct", "eid-1", productTypeContainer, &EntityDetails{})
-		err := releaseVersionWithClient(svc, "MyProduct", "v2.0", "", "notes", "v1.0", true)
+		err := releaseVersionWithClient(svc, "MyProduct", "v2.0", "", "notes", "v1.0", releaseOptions{}, changeSetOptions{noOp: true})
 		if err == nil {
 			t.Fatal("expected error for missing image")
 		}
@@ -143,7 +233,7 @@ func TestReleaseVersionWithClient(t *testing.T) {
 				return &marketplacecatalog.ListEntitiesOutput{}, nil
 			},
 		}
-		err := releaseVersionWithClient(svc, "NonExistent", "v2.0", "img:1", "notes", "v1.0", true)
+		err := releaseVersionWithClient(svc, "NonExistent", "v2.0", "img:1", "notes", "v1.0", releaseOptions{}, changeSetOptions{noOp: true})
 		if err == nil {
 			t.Fatal("expected error")
 		}
@@ -161,9 +251,171 @@ func TestReleaseVersionWithClient(t *testing.T) {
 				return nil, errors.New("describe failed")
 			},
 		}
-		err := releaseVersionWithClient(svc, "MyProduct", "v2.0", "img:1", "notes", "v1.0", true)
+		err := releaseVersionWithClient(svc, "MyProduct", "v2.0", "img:1", "notes", "v1.0", releaseOptions{}, changeSetOptions{noOp: true})
 		if err == nil {
 			t.Fatal("expected error")
 		}
 	})
 }
+
+func TestNextReleaseVersion(t *testing.T) {
+	var details EntityDetails
+	live := `{"Versions":[{"VersionTitle":"1.10.0","CreationDate":"2024-01-01T00:00:00Z"},
+		{"VersionTitle":"1.9.0","CreationDate":"2024-06-01T00:00:00Z"},{"VersionTitle":"nightly"}]}`
+	if err := json.Unmarshal([]byte(live), &details); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, version, bump, want, wantErr string
+	}{
+		{name: "bumps the highest semantic version, not the newest", bump: "minor", want: "1.11.0"},
+		{name: "accepts a newer explicit title", version: "1.10.1", want: "1.10.1"},
+		{name: "accepts titles that are not semantic versions", version: "edge", want: "edge"},
+		{name: "rejects older titles", version: "1.9.5", wantErr: "not newer than the latest version 1.10.0"},
+		{name: "rejects existing titles", version: "nightly", wantErr: "already exists"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := nextReleaseVersion(&details, tc.version, releaseOptions{bump: tc.bump})
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := nextReleaseVersion(&EntityDetails{}, "", releaseOptions{bump: "patch"}); err == nil {
+		t.Error("expected error when no remote version is a semantic version")
+	}
+}
+
+func TestNextReleaseVersionOnOlderLine(t *testing.T) {
+	var details EntityDetails
+	live := `{"Versions":[{"VersionTitle":"2.3.4","CreationDate":"2024-01-01T00:00:00Z"},
+		{"VersionTitle":"3.0.0","CreationDate":"2024-06-01T00:00:00Z"}]}`
+	if err := json.Unmarshal([]byte(live), &details); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, version, want, wantErr string
+		release                      releaseOptions
+	}{
+		{name: "accepts a version newer than its line", version: "2.3.5", release: releaseOptions{baseConstraint: "~2.3"}, want: "2.3.5"},
+		{name: "bumps the highest version of its line", release: releaseOptions{bump: "patch", baseConstraint: "~2.3"}, want: "2.3.5"},
+		{name: "rejects a version older than its line", version: "2.3.3", release: releaseOptions{baseConstraint: "~2.3"}, wantErr: "not newer than the latest version 2.3.4"},
+		{name: "compares with the whole product without a constraint", version: "2.3.5", wantErr: "not newer than the latest version 3.0.0"},
+		{name: "invalid constraint", version: "2.3.5", release: releaseOptions{baseConstraint: "~latest"}, wantErr: "invalid version constraint"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := nextReleaseVersion(&details, tc.version, tc.release)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRequestedVersion(t *testing.T) {
+	if _, err := requestedVersion("1.0.0", releaseOptions{bump: "patch"}); err == nil {
+		t.Error("expected error for a version together with --bump")
+	}
+	if _, err := requestedVersion("", releaseOptions{bump: "huge"}); err == nil {
+		t.Error("expected error for an unknown bump")
+	}
+	if got, err := requestedVersion("", releaseOptions{bump: "patch"}); err != nil || got != "patch" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
+
+func TestReleaseVersionWithBump(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	details := makeEntityDetailsWithVersion(t, "v1.0")
+	svc := foundMock(t, "MyProduct", "eid-1", productTypeContainer, details)
+	err := releaseVersionWithClient(svc, "MyProduct", "", "ecr:v1.1", "notes", "", releaseOptions{bump: "minor"}, changeSetOptions{noOp: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := getYAMLData(filepath.Join("data", "MyProduct", "versions", "v1.1.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Versiontitle != "v1.1" {
+		t.Errorf("version title = %q, want v1.1", data.Versiontitle)
+	}
+}
+
+func TestResolveBaseVersionBySemver(t *testing.T) {
+	var details EntityDetails
+	live := `{"Versions":[
+		{"VersionTitle":"2.3.1","CreationDate":"2024-01-01T00:00:00Z"},
+		{"VersionTitle":"2.4.0-beta.2","CreationDate":"2024-02-01T00:00:00Z"},
+		{"VersionTitle":"2.4.0-beta.10","CreationDate":"2024-03-01T00:00:00Z"},
+		{"VersionTitle":"1.9.5","CreationDate":"2024-04-01T00:00:00Z"}]}`
+	if err := json.Unmarshal([]byte(live), &details); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		release releaseOptions
+		want    string
+		wantErr string
+	}{
+		{name: "ignores a newer hotfix on an older line", want: "2.3.1"},
+		{name: "constraint", release: releaseOptions{baseConstraint: "~1.9"}, want: "1.9.5"},
+		{name: "channel", release: releaseOptions{channel: "beta"}, want: "2.4.0-beta.10"},
+		{name: "channel and constraint", release: releaseOptions{channel: "beta", baseConstraint: "~2.3"}, wantErr: "no version is the highest semantic version matching ~2.3 on the beta channel"},
+		{name: "invalid constraint", release: releaseOptions{baseConstraint: "~latest"}, wantErr: "invalid version constraint"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveBaseVersion(&details, "", tc.release)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := resolveBaseVersion(&details, "2.3.1", releaseOptions{channel: "beta"}); err == nil {
+		t.Error("expected error for --base-version together with --channel")
+	}
+
+	var dated EntityDetails
+	if err := json.Unmarshal([]byte(`{"Versions":[{"VersionTitle":"spring","CreationDate":"2024-01-01T00:00:00Z"},
+		{"VersionTitle":"autumn","CreationDate":"2024-09-01T00:00:00Z"}]}`), &dated); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := resolveBaseVersion(&dated, "", releaseOptions{}); err != nil || got != "autumn" {
+		t.Errorf("got %q, %v, want the newest version for titles that are not semantic versions", got, err)
+	}
+}
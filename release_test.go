@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -118,6 +119,35 @@ func TestUpdateVersionYAML(t *testing.T) {
 	}
 }
 
+func TestUpdateVersionYAMLPreservesLayout(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	dir := filepath.Join("data", "TestProduct", "versions")
+	_ = os.MkdirAll(dir, 0o755)
+	filePath := filepath.Join(dir, "1.1.yaml")
+	if err := os.WriteFile(filePath, []byte(commentedVersionYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateVersionYAML("TestProduct", "1.1", "app:1.1", "Bug fixes"); err != nil {
+		t.Fatalf("updateVersionYAML() error: %v", err)
+	}
+
+	got, _ := os.ReadFile(filePath)
+	want := strings.NewReplacer(
+		`versiontitle: "1.0"`, `versiontitle: "1.1"`,
+		"releasenotes: |-\n  Old notes\n  # part of the notes\n\n  more notes", "releasenotes: Bug fixes",
+		"123.dkr.ecr.us-east-1.amazonaws.com/app:1.0", "app:1.1",
+		"123.dkr.ecr.us-east-1.amazonaws.com/sidecar:1.0", "app:1.1",
+	).Replace(commentedVersionYAML)
+	if string(got) != want {
+		t.Errorf("updated file:\n%s\nwant:\n%s", got, want)
+	}
+}
+
 func TestLatestVersion(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -133,42 +163,7 @@ func TestLatestVersion(t *testing.T) {
 		{
 			name: "single version",
 			details: &EntityDetails{
-				Versions: []struct {
-					ID                  string    `json:"Id"`
-					ReleaseNotes        string    `json:"ReleaseNotes"`
-					UpgradeInstructions string    `json:"UpgradeInstructions"`
-					VersionTitle        string    `json:"VersionTitle"`
-					CreationDate        time.Time `json:"CreationDate"`
-					Sources             []struct {
-						Type          string   `json:"Type"`
-						ID            string   `json:"Id"`
-						Images        []string `json:"Images"`
-						Compatibility struct {
-							Platform string `json:"Platform"`
-						} `json:"Compatibility"`
-					} `json:"Sources"`
-					DeliveryOptions []struct {
-						ID               string `json:"Id"`
-						Type             string `json:"Type"`
-						SourceID         string `json:"SourceId"`
-						Title            string `json:"Title"`
-						ShortDescription string `json:"ShortDescription"`
-						IsRecommended    bool   `json:"isRecommended"`
-						Compatibility    struct {
-							AWSServices []string `json:"AWSServices"`
-						} `json:"Compatibility"`
-						Instructions struct {
-							Usage string `json:"Usage"`
-						} `json:"Instructions"`
-						Recommendations struct {
-							DeploymentResources []struct {
-								Text string `json:"Text"`
-								URL  string `json:"Url"`
-							} `json:"DeploymentResources"`
-						} `json:"Recommendations"`
-						Visibility string `json:"Visibility"`
-					} `json:"DeliveryOptions"`
-				}{
+				Versions: []EntityVersion{
 					{VersionTitle: "v1.0", CreationDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
 				},
 			},
@@ -177,42 +172,7 @@ func TestLatestVersion(t *testing.T) {
 		{
 			name: "picks newest by creation date",
 			details: &EntityDetails{
-				Versions: []struct {
-					ID                  string    `json:"Id"`
-					ReleaseNotes        string    `json:"ReleaseNotes"`
-					UpgradeInstructions string    `json:"UpgradeInstructions"`
-					VersionTitle        string    `json:"VersionTitle"`
-					CreationDate        time.Time `json:"CreationDate"`
-					Sources             []struct {
-						Type          string   `json:"Type"`
-						ID            string   `json:"Id"`
-						Images        []string `json:"Images"`
-						Compatibility struct {
-							Platform string `json:"Platform"`
-						} `json:"Compatibility"`
-					} `json:"Sources"`
-					DeliveryOptions []struct {
-						ID               string `json:"Id"`
-						Type             string `json:"Type"`
-						SourceID         string `json:"SourceId"`
-						Title            string `json:"Title"`
-						ShortDescription string `json:"ShortDescription"`
-						IsRecommended    bool   `json:"isRecommended"`
-						Compatibility    struct {
-							AWSServices []string `json:"AWSServices"`
-						} `json:"Compatibility"`
-						Instructions struct {
-							Usage string `json:"Usage"`
-						} `json:"Instructions"`
-						Recommendations struct {
-							DeploymentResources []struct {
-								Text string `json:"Text"`
-								URL  string `json:"Url"`
-							} `json:"DeploymentResources"`
-						} `json:"Recommendations"`
-						Visibility string `json:"Visibility"`
-					} `json:"DeliveryOptions"`
-				}{
+				Versions: []EntityVersion{
 					{VersionTitle: "v1.0", CreationDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
 					{VersionTitle: "v1.2", CreationDate: time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)},
 					{VersionTitle: "v1.1", CreationDate: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
@@ -274,7 +234,7 @@ func TestReleaseVersionValidation(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			err := releaseVersion(tc.product, tc.version, tc.image, tc.releaseNotes, "", true)
+			err := releaseVersion(tc.product, tc.version, tc.image, tc.releaseNotes, "", releaseOptions{}, changeSetOptions{noOp: true})
 			if err == nil {
 				t.Fatal("expected error, got nil")
 			}
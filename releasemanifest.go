@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+	"gopkg.in/yaml.v2"
+)
+
+// releaseManifest lists the products released together by release --manifest.
+type releaseManifest struct {
+	Products []releaseManifestProduct
+}
+
+// releaseManifestProduct is the release of one product in a release manifest. Its keys are the release flags
+// without dashes, Images maps repository names to new image URIs like --image name=uri, and file and repository
+// paths are relative to the manifest.
+type releaseManifestProduct struct {
+	Product                   string
+	Version                   string
+	Bump                      string
+	Baseversion               string
+	Image                     string
+	Images                    map[string]string
+	Imagesfile                string
+	Ami                       string
+	Addonversion              string
+	Releasenotes              string
+	Releasenotesfile          string
+	Releasenotesfromchangelog string
+	Releasenotesfromgit       string
+	Since                     string
+}
+
+// loadReleaseManifest reads a release manifest, rejecting unknown keys so a typo does not silently drop a setting.
+func loadReleaseManifest(file string) (releaseManifest, error) {
+	data, err := os.ReadFile(file) //nolint:gosec // G304: the manifest path is a CLI flag value provided by the operator
+	if err != nil {
+		return releaseManifest{}, fmt.Errorf("failed to read release manifest: %w", err)
+	}
+	var manifest releaseManifest
+	if err := yaml.UnmarshalStrict(data, &manifest); err != nil {
+		return releaseManifest{}, fmt.Errorf("failed to parse release manifest %s: %w", file, err)
+	}
+	if len(manifest.Products) == 0 {
+		return releaseManifest{}, fmt.Errorf("release manifest %s lists no products", file)
+	}
+	return manifest, nil
+}
+
+// manifestPath resolves a path listed in the manifest in dir against it, leaving empty and absolute paths alone.
+func manifestPath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// releaseNotes returns where the release notes of the product come from.
+func (p releaseManifestProduct) releaseNotes(dir string) (releaseNotesOptions, error) {
+	switch {
+	case p.Releasenotes != "" && p.Releasenotesfile != "":
+		return releaseNotesOptions{}, errors.New("releasenotes and releasenotesfile cannot be combined")
+	case p.Since != "" && p.Releasenotesfromgit == "":
+		return releaseNotesOptions{}, errors.New("since can only be used with releasenotesfromgit")
+	}
+	notes := releaseNotesOptions{
+		text:      p.Releasenotes,
+		file:      manifestPath(dir, p.Releasenotesfile),
+		changelog: manifestPath(dir, p.Releasenotesfromchangelog),
+		gitRepo:   manifestPath(dir, p.Releasenotesfromgit),
+		gitSince:  p.Since,
+	}
+	return notes, checkReleaseNotesSources(notes)
+}
+
+// releaseOptions returns the settings of the product's release on top of those shared by the whole manifest.
+func (p releaseManifestProduct) releaseOptions(shared releaseOptions, dir string) (releaseOptions, error) {
+	release := shared
+	release.bump = p.Bump
+	release.ami = p.Ami
+	release.addOnVersion = p.Addonversion
+	release.imagesFile = manifestPath(dir, p.Imagesfile)
+	if p.Image != "" && len(p.Images) > 0 {
+		return releaseOptions{}, errors.New("image and images cannot be combined")
+	}
+	names := make([]string, 0, len(p.Images))
+	for name := range p.Images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		release.images = append(release.images, name+"="+p.Images[name])
+	}
+	return release, nil
+}
+
+// planManifestProduct checks the release of one product of a manifest against its live versions.
+func planManifestProduct(svc marketplaceClient, p releaseManifestProduct, dir string, shared releaseOptions, opts changeSetOptions) (releasePlan, error) {
+	release, err := p.releaseOptions(shared, dir)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	release.notes, err = p.releaseNotes(dir)
+	if err != nil {
+		return releasePlan{}, err
+	}
+	return planReleaseWithClient(svc, p.Product, p.Version, p.Image, "", p.Baseversion, release, opts)
+}
+
+// planReleaseManifest validates the release of every product in the manifest before any of them starts.
+func planReleaseManifest(svc marketplaceClient, file string, shared releaseOptions, opts changeSetOptions) ([]releasePlan, error) {
+	if opts.requestToken != "" {
+		return nil, errors.New("--request-token cannot be used with --manifest, every product needs a change set token of its own")
+	}
+	manifest, err := loadReleaseManifest(file)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	plans := make([]releasePlan, 0, len(manifest.Products))
+	for i, p := range manifest.Products {
+		switch {
+		case p.Product == "":
+			return nil, fmt.Errorf("product %d of the release manifest has no name", i+1)
+		case seen[p.Product]:
+			return nil, fmt.Errorf("product %s is listed more than once in the release manifest", p.Product)
+		}
+		seen[p.Product] = true
+		plan, err := planManifestProduct(svc, p, filepath.Dir(file), shared, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p.Product, err)
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+func printReleaseSummary(plans []releasePlan, results []changeSetResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PRODUCT\tVERSION\tCHANGESET\tSTATUS")
+	for i, plan := range plans {
+		id := results[i].id
+		if id == "" {
+			id = "-"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", plan.productName, plan.newVersion, id, results[i].status)
+	}
+	return w.Flush()
+}
+
+// releaseManifestWithClient releases every product of a manifest in order, once all of them passed validation.
+// It stops at the first product that fails, leaving the rest unreleased, and prints the change set of each.
+func releaseManifestWithClient(svc marketplaceClient, file string, shared releaseOptions, opts changeSetOptions) error {
+	plans, err := planReleaseManifest(svc, file, shared, opts)
+	if err != nil {
+		return err
+	}
+	results := make([]changeSetResult, len(plans))
+	for i := range results {
+		results[i].status = "SKIPPED"
+	}
+	var releaseErr error
+	for i, plan := range plans {
+		fmt.Printf("Releasing %s version %s\n", plan.productName, plan.newVersion)
+		productOpts := opts
+		productOpts.result = &results[i]
+		results[i].status = "ERROR"
+		if err := runRelease(svc, plan, productOpts); err != nil {
+			releaseErr = fmt.Errorf("%s: %w", plan.productName, err)
+			break
+		}
+	}
+	if err := printReleaseSummary(plans, results); err != nil {
+		return err
+	}
+	return releaseErr
+}
+
+func releaseManifestFile(file string, shared releaseOptions, opts changeSetOptions) error {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return fmt.Errorf("couldn't load AWS config: %w", err)
+	}
+	return releaseManifestWithClient(marketplacecatalog.NewFromConfig(cfg), file, shared, opts)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog/types"
+)
+
+// suiteMock finds the container products Alpha and Beta, with versions 1.0 and 2.0, and starts a change set
+// named after the entity it changes.
+func suiteMock(t *testing.T, started *[]string) *mockMarketplaceClient {
+	t.Helper()
+	details := map[string]*EntityDetails{
+		"eid-alpha": makeEntityDetailsWithVersion(t, "1.0"),
+		"eid-beta":  makeEntityDetailsWithVersion(t, "2.0"),
+	}
+	return &mockMarketplaceClient{
+		listEntitiesFunc: func(_ context.Context, params *marketplacecatalog.ListEntitiesInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListEntitiesOutput, error) {
+			if *params.EntityType != productTypeContainer {
+				return &marketplacecatalog.ListEntitiesOutput{}, nil
+			}
+			return &marketplacecatalog.ListEntitiesOutput{EntitySummaryList: []types.EntitySummary{
+				{Name: aws.String("Alpha"), EntityId: aws.String("eid-alpha")},
+				{Name: aws.String("Beta"), EntityId: aws.String("eid-beta")},
+			}}, nil
+		},
+		describeEntityFunc: func(_ context.Context, params *marketplacecatalog.DescribeEntityInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeEntityOutput, error) {
+			return makeDescribeOutput(t, details[*params.EntityId]), nil
+		},
+		startChangeSetFunc: func(_ context.Context, params *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+			id := "cs-" + *params.ChangeSet[0].Entity.Identifier
+			*started = append(*started, id)
+			return &marketplacecatalog.StartChangeSetOutput{ChangeSetId: aws.String(id)}, nil
+		},
+	}
+}
+
+func writeSuiteVersions(t *testing.T) {
+	t.Helper()
+	files := map[string]string{
+		filepath.Join("data", "Alpha", "versions", "1.0.yaml"): "versiontitle: \"1.0\"\nreleasenotes: notes\nsources:\n- id: src-1\n  images:\n  - ecr/alpha:1.0\n",
+		filepath.Join("data", "Beta", "versions", "2.0.yaml"):  "versiontitle: \"2.0\"\nreleasenotes: notes\nsources:\n- id: src-1\n  images:\n  - ecr/beta:2.0\n  - ecr/beta-worker:2.0\n",
+	}
+	for file, content := range files {
+		_ = os.MkdirAll(filepath.Dir(file), 0o755)
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeReleaseManifest(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join("release", "release.yaml")
+	_ = os.MkdirAll("release", 0o755)
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReleaseManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	writeSuiteVersions(t)
+	_ = os.MkdirAll("release", 0o755)
+	if err := os.WriteFile(filepath.Join("release", "beta.md"), []byte("## Fixes\n\n* Beta fix\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest := writeReleaseManifest(t, `products:
+- product: Alpha
+  bump: minor
+  image: ecr/alpha:1.1
+  releasenotes: Alpha notes
+- product: Beta
+  version: "2.1"
+  baseversion: "2.0"
+  images:
+    beta: ecr/beta:2.1
+    beta-worker: ecr/beta-worker:2.1
+  releasenotesfile: beta.md
+`)
+
+	var started []string
+	svc := suiteMock(t, &started)
+	if err := releaseManifestWithClient(svc, manifest, releaseOptions{}, changeSetOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(started, ",") != "cs-eid-alpha,cs-eid-beta" {
+		t.Errorf("started change sets = %v", started)
+	}
+	alpha, _ := os.ReadFile(filepath.Join("data", "Alpha", "versions", "1.1.yaml"))
+	if !strings.Contains(string(alpha), "ecr/alpha:1.1") || !strings.Contains(string(alpha), "Alpha notes") {
+		t.Errorf("Alpha 1.1:\n%s", alpha)
+	}
+	beta, _ := os.ReadFile(filepath.Join("data", "Beta", "versions", "2.1.yaml"))
+	for _, want := range []string{"ecr/beta:2.1", "ecr/beta-worker:2.1", "Beta fix"} {
+		if !strings.Contains(string(beta), want) {
+			t.Errorf("Beta 2.1 does not contain %q:\n%s", want, beta)
+		}
+	}
+}
+
+func TestReleaseManifestPerProductFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	writeSuiteVersions(t)
+	_ = os.MkdirAll("release", 0o755)
+	files := map[string]string{
+		"images.yaml":  "beta: ecr/beta:2.1\nbeta-worker: ecr/beta-worker:2.1\n",
+		"CHANGELOG.md": "## [Unreleased]\n\n- Beta change\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join("release", name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest := writeReleaseManifest(t, `products:
+- product: Beta
+  version: "2.1"
+  imagesfile: images.yaml
+  releasenotesfromchangelog: CHANGELOG.md
+`)
+
+	var started []string
+	if err := releaseManifestWithClient(suiteMock(t, &started), manifest, releaseOptions{}, changeSetOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	beta, _ := os.ReadFile(filepath.Join("data", "Beta", "versions", "2.1.yaml"))
+	for _, want := range []string{"ecr/beta:2.1", "ecr/beta-worker:2.1", "- Beta change"} {
+		if !strings.Contains(string(beta), want) {
+			t.Errorf("Beta 2.1 does not contain %q:\n%s", want, beta)
+		}
+	}
+}
+
+func TestReleaseManifestValidatesUpFront(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	valid := "- product: Alpha\n  version: \"1.1\"\n  image: ecr/alpha:1.1\n  releasenotes: notes\n"
+	beta := "- product: Beta\n  version: \"2.1\"\n  releasenotes: notes\n"
+	tests := []struct {
+		name     string
+		products string
+		betaBase string
+		opts     changeSetOptions
+		wantErr  string
+	}{
+		{"unknown product", valid + "- product: Gamma\n  version: \"1.0\"\n  image: ecr/gamma:1.0\n  releasenotes: notes\n", "", changeSetOptions{}, "Gamma: could not find product"},
+		{"existing version", valid + "- product: Beta\n  version: \"2.0\"\n  image: ecr/beta:2.0\n  releasenotes: notes\n", "", changeSetOptions{}, "Beta: version 2.0 already exists"},
+		{"missing notes file", valid + "- product: Beta\n  version: \"2.1\"\n  image: ecr/beta:2.1\n  releasenotesfile: missing.md\n", "", changeSetOptions{}, "Beta: failed to read release notes file"},
+		{"image and images", valid + "- product: Beta\n  version: \"2.1\"\n  image: ecr/beta:2.1\n  images:\n    beta: ecr/beta:2.1\n  releasenotes: notes\n", "", changeSetOptions{}, "image and images cannot be combined"},
+		{"two notes sources", valid + "- product: Beta\n  version: \"2.1\"\n  image: ecr/beta:2.1\n  releasenotes: notes\n  releasenotesfromchangelog: CHANGELOG.md\n", "", changeSetOptions{}, "not several"},
+		{"since without git", valid + "- product: Beta\n  version: \"2.1\"\n  image: ecr/beta:2.1\n  releasenotes: notes\n  since: v1.0\n", "", changeSetOptions{}, "since can only be used with releasenotesfromgit"},
+		{"ami on a container product", valid + "- product: Beta\n  version: \"2.1\"\n  ami: ami-0123456789abcdef0\n  releasenotes: notes\n", "", changeSetOptions{}, "--ami can only be used with"},
+		{"unmapped image", valid + beta + "  images:\n    beta: ecr/beta:2.1\n", "", changeSetOptions{}, "Beta: failed to update version YAML: no image mapping for ecr/beta-worker:2.0"},
+		{"unused mapping", valid + beta + "  images:\n    beta: ecr/beta:2.1\n    beta-worker: ecr/beta-worker:2.1\n    cache: ecr/cache:2.1\n", "", changeSetOptions{}, "Beta: failed to update version YAML: image mapping cache=ecr/cache:2.1 does not match"},
+		{"add-on version without add-on", valid + beta + "  image: ecr/beta:2.1\n  addonversion: v2.1\n", "", changeSetOptions{}, "Beta: failed to update EKS add-on version"},
+		{"server delivery option", valid + beta + "  image: ecr/beta:2.1\n", "versiontitle: \"2.0\"\nreleasenotes: notes\nsources:\n- id: src-1\n  images:\n  - ecr/beta:2.0\ndeliveryoptions:\n- title: AMI\n  type: AmazonMachineImage\n", changeSetOptions{}, "Beta: delivery option \"AMI\" has type \"AmazonMachineImage\""},
+		{"duplicate product", valid + valid, "", changeSetOptions{}, "Alpha is listed more than once"},
+		{"unknown key", valid + "  imagez: typo\n", "", changeSetOptions{}, "field imagez not found"},
+		{"shared request token", valid, "", changeSetOptions{requestToken: "token"}, "--request-token cannot be used with --manifest"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			writeSuiteVersions(t)
+			if tc.betaBase != "" {
+				if err := os.WriteFile(filepath.Join("data", "Beta", "versions", "2.0.yaml"), []byte(tc.betaBase), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			manifest := writeReleaseManifest(t, "products:\n"+tc.products)
+			var started []string
+			err := releaseManifestWithClient(suiteMock(t, &started), manifest, releaseOptions{}, tc.opts)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %v, want %q", err, tc.wantErr)
+			}
+			if len(started) > 0 {
+				t.Errorf("started change sets %v for an invalid manifest", started)
+			}
+			if _, err := os.Stat(filepath.Join("data", "Alpha", "versions", "1.1.yaml")); !os.IsNotExist(err) {
+				t.Error("an invalid manifest should not write any version")
+			}
+		})
+	}
+}
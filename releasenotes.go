@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// releaseNotesOptions holds the places release notes can come from. Only one of them is used.
+type releaseNotesOptions struct {
+	text      string
+	file      string
+	changelog string
+	gitRepo   string
+	gitSince  string
+}
+
+// source names where the release notes come from, and is empty when none was given.
+func (o releaseNotesOptions) source() string {
+	switch {
+	case o.changelog != "":
+		return "changelog " + o.changelog
+	case o.gitRepo != "":
+		return "git history of " + o.gitRepo
+	case o.file != "":
+		return "file " + o.file
+	case o.text != "":
+		return "inline text"
+	}
+	return ""
+}
+
+// changelogHeading matches the version headings of a Keep a Changelog file, such as "## [1.2.0] - 2024-05-01".
+var changelogHeading = regexp.MustCompile(`^##\s+\[?([^\]\s]+)\]?`)
+
+// changelogSections maps the lowercased version of every section in a Keep a Changelog file to its body.
+func changelogSections(changelog string) map[string]string {
+	sections := map[string]string{}
+	current := ""
+	var body []string
+	for _, line := range strings.Split(strings.ReplaceAll(changelog, "\r\n", "\n"), "\n") {
+		if m := changelogHeading.FindStringSubmatch(line); m != nil {
+			current, body = strings.ToLower(m[1]), nil
+			continue
+		}
+		if current != "" {
+			body = append(body, line)
+			sections[current] = strings.TrimSpace(strings.Join(body, "\n"))
+		}
+	}
+	return sections
+}
+
+// changelogSection returns the body of the section for version in a Keep a Changelog file. When the file has
+// no section for it yet, or no version is given, the Unreleased section is used.
+func changelogSection(changelog, version string) (string, error) {
+	sections := changelogSections(changelog)
+	keys := []string{"Unreleased"}
+	if bare := strings.TrimPrefix(version, "v"); bare != "" {
+		keys = append([]string{version, bare, "v" + bare}, keys...)
+	}
+	for _, key := range keys {
+		if notes := sections[strings.ToLower(key)]; notes != "" {
+			fmt.Printf("Release notes taken from the %s section of the changelog\n", key)
+			return notes, nil
+		}
+	}
+	if version == "" {
+		return "", errors.New("the changelog has no Unreleased section with any entries")
+	}
+	return "", fmt.Errorf("the changelog has no section for version %s and no Unreleased entries", version)
+}
+
+// conventionalCommit matches "type(scope)!: description" commit subjects.
+var conventionalCommit = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?:\s+(.+)$`)
+
+// Release notes sections for the conventional commit types that matter to buyers. Other types, such as chore or
+// docs, are left out.
+var commitSections = []struct {
+	commitType string
+	title      string
+}{
+	{"breaking", "Breaking changes"},
+	{"feat", "Features"},
+	{"fix", "Bug fixes"},
+	{"perf", "Performance improvements"},
+}
+
+// conventionalNotes groups commit messages, each a subject optionally followed by a body, into release notes.
+func conventionalNotes(messages []string) string {
+	entries := map[string][]string{}
+	for _, msg := range messages {
+		subject, body, _ := strings.Cut(strings.TrimSpace(msg), "\n")
+		m := conventionalCommit.FindStringSubmatch(subject)
+		if m == nil {
+			continue
+		}
+		commitType, entry := strings.ToLower(m[1]), m[4]
+		if m[2] != "" {
+			entry = m[2] + ": " + entry
+		}
+		if m[3] == "!" || strings.Contains(body, "BREAKING CHANGE:") || strings.Contains(body, "BREAKING-CHANGE:") {
+			commitType = "breaking"
+		}
+		entries[commitType] = append(entries[commitType], entry)
+	}
+
+	var notes []string
+	for _, section := range commitSections {
+		if len(entries[section.commitType]) == 0 {
+			continue
+		}
+		notes = append(notes, "## "+section.title+"\n\n- "+strings.Join(entries[section.commitType], "\n- "))
+	}
+	return strings.Join(notes, "\n\n")
+}
+
+// gitCommitMessages returns the messages of the commits in repo after since. since always reaches git as a
+// revision, even when it starts with a dash.
+func gitCommitMessages(repo, since string) ([]string, error) {
+	out, err := runGit(repo, "log", "--no-merges", "--format=%B%x1e", "--end-of-options", since+"..HEAD")
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for _, msg := range strings.Split(out, "\x1e") {
+		if msg = strings.TrimSpace(msg); msg != "" {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+func runGit(repo string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...) //nolint:gosec // G204: runs git with the repository and revision given by the operator
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// gitReleaseNotes builds release notes from the conventional commits in repo since a tag, by default the latest.
+func gitReleaseNotes(repo, since string) (string, error) {
+	if since == "" {
+		out, err := runGit(repo, "describe", "--tags", "--abbrev=0")
+		if err != nil {
+			return "", fmt.Errorf("could not find the latest tag, pass --since: %w", err)
+		}
+		since = strings.TrimSpace(out)
+	}
+	messages, err := gitCommitMessages(repo, since)
+	if err != nil {
+		return "", err
+	}
+	notes := conventionalNotes(messages)
+	if notes == "" {
+		return "", fmt.Errorf("no feat, fix or perf commits found in %s since %s", repo, since)
+	}
+	return notes, nil
+}
+
+var (
+	markdownHeading = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	markdownBullet  = regexp.MustCompile(`^(\s*)[*+]\s+`)
+	markdownLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	markdownEmph    = regexp.MustCompile(`(\*\*|__)(.+?)(\*\*|__)`)
+	markdownComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+	markdownRefLink = regexp.MustCompile(`^\[[^\]]+\]:\s+\S+\s*$`)
+)
+
+// normalizeReleaseNotes turns Markdown into the plain text the Marketplace shows as release notes: headings
+// become lines of their own, every bullet uses "-", links show their URL and emphasis, comments and link
+// references are dropped, and blank lines are collapsed.
+func normalizeReleaseNotes(notes string) string {
+	notes = markdownComment.ReplaceAllString(strings.ReplaceAll(notes, "\r\n", "\n"), "")
+	var out []string
+	for _, line := range strings.Split(notes, "\n") {
+		line = strings.TrimRight(line, " \t")
+		if markdownRefLink.MatchString(line) {
+			continue
+		}
+		if m := markdownHeading.FindStringSubmatch(line); m != nil {
+			line = m[1]
+		}
+		line = markdownBullet.ReplaceAllString(line, "$1- ")
+		line = markdownLink.ReplaceAllString(line, "$1 ($2)")
+		line = markdownEmph.ReplaceAllString(line, "$2")
+		if line == "" && (len(out) == 0 || out[len(out)-1] == "") {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
+
+// generatedReleaseNotes builds release notes from a changelog or git history, or returns false when neither
+// was asked for.
+func generatedReleaseNotes(opts releaseNotesOptions, version string) (string, bool, error) {
+	switch {
+	case opts.changelog != "":
+		data, err := os.ReadFile(opts.changelog) //nolint:gosec // G304: the changelog path is a CLI flag value provided by the operator
+		if err != nil {
+			return "", true, fmt.Errorf("failed to read changelog: %w", err)
+		}
+		notes, err := changelogSection(string(data), version)
+		return normalizeReleaseNotes(notes), true, err
+	case opts.gitRepo != "":
+		notes, err := gitReleaseNotes(opts.gitRepo, opts.gitSince)
+		return normalizeReleaseNotes(notes), true, err
+	}
+	return "", false, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testChangelog = `# Changelog
+
+## [Unreleased]
+
+### Added
+- Spot placement scores
+
+## [1.2.0] - 2024-05-01
+
+### Fixed
+- **Crash** when the ASG has no instances ([#42](https://github.com/example/app/issues/42))
+
+<!-- internal: do not ship -->
+
+## [1.1.0] - 2024-04-01
+
+### Added
+- Initial release
+
+[1.2.0]: https://github.com/example/app/compare/v1.1.0...v1.2.0
+`
+
+func TestChangelogSection(t *testing.T) {
+	tests := []struct {
+		version, want, wantErr string
+	}{
+		{version: "1.2.0", want: "### Fixed\n- **Crash**"},
+		{version: "v1.1.0", want: "### Added\n- Initial release"},
+		{version: "1.3.0", want: "### Added\n- Spot placement scores"},
+		{version: "", want: "### Added\n- Spot placement scores"},
+	}
+	for _, tc := range tests {
+		got, err := changelogSection(testChangelog, tc.version)
+		if err != nil {
+			t.Fatalf("changelogSection(%q): %v", tc.version, err)
+		}
+		if !strings.HasPrefix(got, tc.want) {
+			t.Errorf("changelogSection(%q) = %q, want prefix %q", tc.version, got, tc.want)
+		}
+	}
+
+	if _, err := changelogSection("## [1.0.0]\n- Initial\n", "2.0.0"); err == nil {
+		t.Error("expected error for a version without a section and no Unreleased entries")
+	}
+}
+
+func TestNormalizeReleaseNotes(t *testing.T) {
+	notes, err := changelogSection(testChangelog, "1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := normalizeReleaseNotes(notes + "\n\n\n* second\r\n  + nested\n## Extra ##\n[#42]: https://example.com/42\n")
+	want := "Fixed\n- Crash when the ASG has no instances (#42 (https://github.com/example/app/issues/42))\n\n" +
+		"- second\n  - nested\nExtra"
+	if got != want {
+		t.Errorf("normalizeReleaseNotes() = %q, want %q", got, want)
+	}
+}
+
+func TestConventionalNotes(t *testing.T) {
+	got := conventionalNotes([]string{
+		"feat(api): add spot scores",
+		"fix: handle empty groups\n\nCloses #42",
+		"chore: bump deps",
+		"Merge branch 'main'",
+		"feat!: drop Python 2",
+		"refactor(core): split scheduler\n\nBREAKING CHANGE: config keys renamed",
+		"perf: cache instance types",
+	})
+	want := "## Breaking changes\n\n- drop Python 2\n- core: split scheduler\n\n" +
+		"## Features\n\n- api: add spot scores\n\n" +
+		"## Bug fixes\n\n- handle empty groups\n\n" +
+		"## Performance improvements\n\n- cache instance types"
+	if got != want {
+		t.Errorf("conventionalNotes() = %q, want %q", got, want)
+	}
+}
+
+func TestGitReleaseNotes(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "feat: first release")
+	git("tag", "v1.0.0")
+	git("commit", "-q", "--allow-empty", "-m", "fix(agent): retry throttled calls")
+	git("commit", "-q", "--allow-empty", "-m", "docs: typo")
+
+	for _, since := range []string{"v1.0.0", ""} {
+		got, err := loadReleaseNotes(releaseNotesOptions{gitRepo: repo, gitSince: since}, "1.0.1")
+		if err != nil {
+			t.Fatalf("since %q: unexpected error: %v", since, err)
+		}
+		if got != "Bug fixes\n\n- agent: retry throttled calls" {
+			t.Errorf("since %q: notes = %q", since, got)
+		}
+	}
+
+	git("tag", "v1.0.1")
+	if _, err := gitReleaseNotes(repo, "v1.0.1"); err == nil {
+		t.Error("expected error when there are no new commits")
+	}
+	if _, err := gitReleaseNotes(repo, "v9.9.9"); err == nil {
+		t.Error("expected error for an unknown tag")
+	}
+	output := filepath.Join(t.TempDir(), "out")
+	if _, err := gitReleaseNotes(repo, "--output="+output); err == nil {
+		t.Error("expected error for a --since value that looks like an option")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Error("a --since value starting with a dash was read as a git option")
+	}
+}
+
+func TestLoadReleaseNotesFromChangelog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "CHANGELOG.md")
+	if err := os.WriteFile(path, []byte(testChangelog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadReleaseNotes(releaseNotesOptions{changelog: path}, "1.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "Added\n- Initial release" {
+		t.Errorf("notes = %q", got)
+	}
+
+	invalid := []releaseNotesOptions{
+		{changelog: path, text: "inline"},
+		{changelog: path, gitRepo: "."},
+		{gitSince: "v1.0.0"},
+	}
+	for _, opts := range invalid {
+		if _, err := loadReleaseNotes(opts, "1.1.0"); err == nil {
+			t.Errorf("expected error for %+v", opts)
+		}
+	}
+}
+
+func TestReleaseWithBumpTakesChangelogSectionOfNewVersion(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	changelog := "# Changelog\n\n## [Unreleased]\n\n- Not released yet\n\n## [v1.1] - 2024-05-01\n\n- New feature\n"
+	if err := os.WriteFile("CHANGELOG.md", []byte(changelog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	details := makeEntityDetailsWithVersion(t, "v1.0")
+	svc := foundMock(t, "MyProduct", "eid-1", productTypeContainer, details)
+	release := releaseOptions{bump: "minor", notes: releaseNotesOptions{changelog: "CHANGELOG.md"}}
+	if err := releaseVersionWithClient(svc, "MyProduct", "", "ecr:v1.1", "", "", release, changeSetOptions{noOp: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := getYAMLData(filepath.Join("data", "MyProduct", "versions", "v1.1.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Releasenotes != "- New feature" {
+		t.Errorf("release notes = %q, want the v1.1 section", data.Releasenotes)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+)
+
+// Delivery option visibilities reported by DescribeEntity.
+const (
+	visibilityPublic     = "Public"
+	visibilityRestricted = "Restricted"
+)
+
+type RestrictDeliveryOptionsDetails struct {
+	DeliveryOptionIDs []string `json:"DeliveryOptionIds"`
+}
+
+// isPublicVersion reports whether buyers can still launch any delivery option of the version.
+func isPublicVersion(version *EntityVersion) bool {
+	for _, opt := range version.DeliveryOptions {
+		if strings.EqualFold(opt.Visibility, visibilityPublic) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictableOptions returns the IDs of the delivery options of the selected versions that are not restricted
+// yet, refusing to go ahead when no public version would be left.
+func restrictableOptions(details *EntityDetails, versions []string) ([]string, error) {
+	selected := map[string]bool{}
+	var ids []string
+	for _, title := range versions {
+		version, err := publishedVersion(details, title)
+		if err != nil {
+			return nil, err
+		}
+		if selected[title] {
+			continue
+		}
+		selected[title] = true
+		for _, opt := range version.DeliveryOptions {
+			if !strings.EqualFold(opt.Visibility, visibilityRestricted) {
+				ids = append(ids, opt.ID)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("every delivery option of the selected versions is already restricted")
+	}
+
+	public := 0
+	for i := range details.Versions {
+		if !selected[details.Versions[i].VersionTitle] && isPublicVersion(&details.Versions[i]) {
+			public++
+		}
+	}
+	if public == 0 {
+		return nil, errors.New("refusing to restrict the last public version of the product, publish a newer version first")
+	}
+	return ids, nil
+}
+
+// restrictVersionsWithClient restricts the delivery options of the given versions, so new buyers can no longer
+// launch them while existing subscribers keep access.
+func restrictVersionsWithClient(svc marketplaceClient, productName string, versions []string, opts changeSetOptions) error {
+	entityID, foundType, err := findProduct(svc, productName)
+	if err != nil {
+		return err
+	}
+	details, err := describeProduct(svc, entityID)
+	if err != nil {
+		return err
+	}
+	ids, err := restrictableOptions(details, versions)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restricting %d delivery options of %s versions %s\n", len(ids), productName, strings.Join(versions, ", "))
+
+	return submitVersionChange(svc, productName, entityID, foundType, versionChange{
+		changeType:    "RestrictDeliveryOptions",
+		changeName:    "RestrictDeliveryOptions",
+		changeSetName: fmt.Sprintf("Restrict %s versions %s", productName, strings.Join(versions, ", ")),
+		details:       RestrictDeliveryOptionsDetails{DeliveryOptionIDs: ids},
+	}, opts)
+}
+
+func restrictVersions(productName string, versions []string, opts changeSetOptions) error {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return errors.New("couldn't load default config")
+	}
+	return restrictVersionsWithClient(marketplacecatalog.NewFromConfig(cfg), productName, versions, opts)
+}
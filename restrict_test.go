@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+)
+
+const restrictLive = `{"Versions":[
+	{"VersionTitle":"v1.0","DeliveryOptions":[{"Id":"do-1","Visibility":"Public"},{"Id":"do-2","Visibility":"Restricted"}]},
+	{"VersionTitle":"v1.1","DeliveryOptions":[{"Id":"do-3","Visibility":"Public"}]},
+	{"VersionTitle":"v0.9","DeliveryOptions":[{"Id":"do-4","Visibility":"Restricted"}]}]}`
+
+func restrictDetails(t *testing.T) *EntityDetails {
+	t.Helper()
+	var details EntityDetails
+	if err := json.Unmarshal([]byte(restrictLive), &details); err != nil {
+		t.Fatal(err)
+	}
+	return &details
+}
+
+func TestRestrictableOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		versions []string
+		want     []string
+		wantErr  string
+	}{
+		{name: "skips restricted options", versions: []string{"v1.0"}, want: []string{"do-1"}},
+		{name: "ignores repeated versions", versions: []string{"v1.0", "v1.0"}, want: []string{"do-1"}},
+		{name: "last public version", versions: []string{"v1.0", "v1.1"}, wantErr: "last public version"},
+		{name: "already restricted", versions: []string{"v0.9"}, wantErr: "already restricted"},
+		{name: "unknown version", versions: []string{"v2.0"}, wantErr: "not published"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := restrictableOptions(restrictDetails(t), tc.versions)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("ids = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRestrictVersionsWithClient(t *testing.T) {
+	t.Run("sends RestrictDeliveryOptions", func(t *testing.T) {
+		svc := foundMock(t, "MyProduct", "eid-1", productTypeContainer, restrictDetails(t))
+		var gotChangeType, gotDetails string
+		svc.startChangeSetFunc = func(_ context.Context, params *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+			gotChangeType = *params.ChangeSet[0].ChangeType
+			gotDetails = *params.ChangeSet[0].Details
+			return &marketplacecatalog.StartChangeSetOutput{}, nil
+		}
+		if err := restrictVersionsWithClient(svc, "MyProduct", []string{"v1.0"}, changeSetOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if gotChangeType != "RestrictDeliveryOptions" {
+			t.Errorf("changeType = %q, want RestrictDeliveryOptions", gotChangeType)
+		}
+		if gotDetails != `{"DeliveryOptionIds":["do-1"]}` {
+			t.Errorf("details = %s", gotDetails)
+		}
+	})
+
+	t.Run("no-op does not call StartChangeSet", func(t *testing.T) {
+		svc := foundMock(t, "MyProduct", "eid-1", productTypeContainer, restrictDetails(t))
+		if err := restrictVersionsWithClient(svc, "MyProduct", []string{"v1.1"}, changeSetOptions{noOp: true}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("refuses the last public version", func(t *testing.T) {
+		svc := foundMock(t, "MyProduct", "eid-1", productTypeContainer, restrictDetails(t))
+		err := restrictVersionsWithClient(svc, "MyProduct", []string{"v1.1", "v1.0"}, changeSetOptions{})
+		if err == nil || !strings.Contains(err.Error(), "last public version") {
+			t.Errorf("error = %v", err)
+		}
+	})
+}
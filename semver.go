@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// semVersion is a version title parsed as a semantic version. Titles may carry a "v" prefix and may leave out the
+// minor and patch numbers, as in "v2" or "1.4"; parts records how many were given so bumps keep the same shape.
+type semVersion struct {
+	prefix     string
+	numbers    [3]int
+	parts      int
+	prerelease []string
+}
+
+// parseSemver parses a version title, reporting whether it is a semantic version. Build metadata is ignored, as
+// it does not take part in ordering.
+func parseSemver(title string) (semVersion, bool) {
+	var v semVersion
+	s := title
+	if strings.HasPrefix(s, "v") || strings.HasPrefix(s, "V") {
+		v.prefix, s = s[:1], s[1:]
+	}
+	s, _, _ = strings.Cut(s, "+")
+	s, pre, hasPre := strings.Cut(s, "-")
+	if hasPre {
+		v.prerelease = strings.Split(pre, ".")
+		if slices.Contains(v.prerelease, "") {
+			return semVersion{}, false
+		}
+	}
+	fields := strings.Split(s, ".")
+	if len(fields) > 3 {
+		return semVersion{}, false
+	}
+	for i, f := range fields {
+		if !isSemverNumber(f) {
+			return semVersion{}, false
+		}
+		v.numbers[i], _ = strconv.Atoi(f)
+	}
+	v.parts = len(fields)
+	return v, true
+}
+
+// isSemverNumber reports whether f is a version number: digits only, without leading zeros.
+func isSemverNumber(f string) bool {
+	if f == "" || (len(f) > 1 && f[0] == '0') {
+		return false
+	}
+	for _, c := range f {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (v semVersion) String() string {
+	nums := make([]string, v.parts)
+	for i := range nums {
+		nums[i] = strconv.Itoa(v.numbers[i])
+	}
+	s := v.prefix + strings.Join(nums, ".")
+	if len(v.prerelease) > 0 {
+		s += "-" + strings.Join(v.prerelease, ".")
+	}
+	return s
+}
+
+// Version bumps accepted by release --bump.
+var semverBumps = []string{"major", "minor", "patch", "prerelease"}
+
+// bumpSemver returns the version following v. Like npm's semver, bumping a prerelease to the release it leads up
+// to only drops the prerelease, so 2.0.0-rc.1 bumps to 2.0.0 for major. A prerelease bump counts up the last
+// numeric identifier, or starts a new patch prerelease at rc.1.
+func bumpSemver(v semVersion, bump string) (semVersion, error) {
+	next := v
+	next.prerelease = nil
+	level := slices.Index(semverBumps, bump)
+	switch {
+	case level < 0:
+		return semVersion{}, fmt.Errorf("invalid bump: %s. Valid bumps are: %s", bump, strings.Join(semverBumps, ", "))
+	case level == 3:
+		return bumpPrerelease(v), nil
+	case len(v.prerelease) > 0 && zeroFrom(v, level+1):
+		return next, nil
+	}
+	next.numbers[level]++
+	for i := level + 1; i < len(next.numbers); i++ {
+		next.numbers[i] = 0
+	}
+	next.parts = max(next.parts, level+1)
+	return next, nil
+}
+
+// zeroFrom reports whether every version number of v from index i on is zero.
+func zeroFrom(v semVersion, i int) bool {
+	for ; i < len(v.numbers); i++ {
+		if v.numbers[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func bumpPrerelease(v semVersion) semVersion {
+	next := v
+	if len(v.prerelease) == 0 {
+		next.numbers[2]++
+		next.parts = 3
+		next.prerelease = []string{"rc", "1"}
+		return next
+	}
+	next.prerelease = slices.Clone(v.prerelease)
+	last := len(next.prerelease) - 1
+	if n, err := strconv.Atoi(next.prerelease[last]); err == nil {
+		next.prerelease[last] = strconv.Itoa(n + 1)
+	} else {
+		next.prerelease = append(next.prerelease, "1")
+	}
+	return next
+}
+
+// compareSemver orders two versions by semantic version precedence, returning -1, 0 or 1.
+func compareSemver(a, b semVersion) int {
+	for i := range a.numbers {
+		if c := compareInts(a.numbers[i], b.numbers[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a.prerelease) == 0 && len(b.prerelease) == 0:
+		return 0
+	case len(a.prerelease) == 0:
+		return 1
+	case len(b.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.prerelease) && i < len(b.prerelease); i++ {
+		if c := comparePrerelease(a.prerelease[i], b.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a.prerelease), len(b.prerelease))
+}
+
+// comparePrerelease orders prerelease identifiers: numeric ones numerically and below alphanumeric ones, which
+// compare as strings.
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.Atoi(a)
+	bn, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareVersionTitles orders version titles by semantic version. Titles that are not semantic versions sort
+// before those that are, and by name among themselves.
+func compareVersionTitles(a, b string) int {
+	av, aOK := parseSemver(a)
+	bv, bOK := parseSemver(b)
+	switch {
+	case aOK && bOK:
+		if c := compareSemver(av, bv); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	case aOK:
+		return 1
+	case bOK:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+// semverComparator is a single condition of a version constraint, using one of =, >, >=, < and <=.
+type semverComparator struct {
+	op string
+	v  semVersion
+}
+
+// semverConstraint is a set of comparators that must all hold, such as "~2.3" or ">=2.1 <3".
+type semverConstraint []semverComparator
+
+var constraintOperators = []string{">=", "<=", ">", "<", "=", "~", "^"}
+
+// parseSemverConstraint parses space-separated comparators. Besides the plain operators it accepts ~ (patch
+// updates, or minor updates when only the major number is given), ^ (updates that keep the leftmost non-zero
+// number) and partial versions such as "2.3" or "2.x", which match every version they are a prefix of.
+func parseSemverConstraint(s string) (semverConstraint, error) {
+	var c semverConstraint
+	for _, f := range strings.Fields(s) {
+		comparators, err := parseComparator(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %w", s, err)
+		}
+		c = append(c, comparators...)
+	}
+	return c, nil
+}
+
+func parseComparator(f string) ([]semverComparator, error) {
+	op := ""
+	for _, o := range constraintOperators {
+		if strings.HasPrefix(f, o) {
+			op, f = o, f[len(o):]
+			break
+		}
+	}
+	v, ok := parsePartialSemver(f)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a version", f)
+	}
+	if v.parts == 0 {
+		if op != "" && op != "=" {
+			return nil, fmt.Errorf("%q needs a version after %s", f, op)
+		}
+		return nil, nil
+	}
+	return expandComparator(op, v), nil
+}
+
+// expandComparator turns the range operators and partial versions into plain comparators.
+func expandComparator(op string, v semVersion) []semverComparator {
+	switch op {
+	case "~":
+		return versionRange(v, bumpedAt(v, min(v.parts-1, 1)))
+	case "^":
+		return versionRange(v, bumpedAt(v, caretIndex(v)))
+	case "", "=":
+		if v.parts < 3 {
+			return versionRange(v, bumpedAt(v, v.parts-1))
+		}
+		return []semverComparator{{op: "=", v: v}}
+	}
+	return []semverComparator{{op: op, v: v}}
+}
+
+// parsePartialSemver parses a version whose trailing numbers may be left out or written as x or *. A version
+// made of wildcards only has no parts and matches anything.
+func parsePartialSemver(s string) (semVersion, bool) {
+	fields := strings.Split(s, ".")
+	for len(fields) > 0 && slices.Contains([]string{"x", "X", "*"}, fields[len(fields)-1]) {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) == 0 {
+		return semVersion{}, true
+	}
+	return parseSemver(strings.Join(fields, "."))
+}
+
+// caretIndex returns the index of the number a ^ constraint may not change: the leftmost non-zero one given.
+func caretIndex(v semVersion) int {
+	i := 0
+	for i < v.parts-1 && v.numbers[i] == 0 {
+		i++
+	}
+	return i
+}
+
+// bumpedAt returns the lowest release above every version that shares v's numbers up to index i.
+func bumpedAt(v semVersion, i int) semVersion {
+	next := semVersion{numbers: v.numbers, parts: 3}
+	next.numbers[i]++
+	for j := i + 1; j < len(next.numbers); j++ {
+		next.numbers[j] = 0
+	}
+	return next
+}
+
+func versionRange(low, high semVersion) []semverComparator {
+	return []semverComparator{{op: ">=", v: low}, {op: "<", v: high}}
+}
+
+// matches reports whether v satisfies every comparator. Only release numbers are compared, so a constraint
+// selects prereleases of the versions it covers.
+func (c semverConstraint) matches(v semVersion) bool {
+	release := semVersion{numbers: v.numbers}
+	for _, cmp := range c {
+		if !comparisonHolds(cmp.op, compareSemver(release, semVersion{numbers: cmp.v.numbers})) {
+			return false
+		}
+	}
+	return true
+}
+
+// comparisonHolds reports whether the result n of compareSemver satisfies op.
+func comparisonHolds(op string, n int) bool {
+	switch op {
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	}
+	return n == 0
+}
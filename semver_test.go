@@ -0,0 +1,116 @@
+package main
+
+import "testing"
+
+func TestParseSemver(t *testing.T) {
+	for _, title := range []string{"1.0.0", "v2", "1.4", "V1.2.3-rc.1", "1.0.0+build.5", "1.0.0-alpha+001"} {
+		if _, ok := parseSemver(title); !ok {
+			t.Errorf("parseSemver(%q) should succeed", title)
+		}
+	}
+	for _, title := range []string{"", "latest", "1.2.3.4", "01.2", "1.x", "1.0-", "1.0.0-rc..1", "v"} {
+		if _, ok := parseSemver(title); ok {
+			t.Errorf("parseSemver(%q) should fail", title)
+		}
+	}
+}
+
+func TestCompareVersionTitles(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.10.0", "1.9.0", 1},
+		{"v1.2", "1.2.0", 1}, // same precedence, ordered by name
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.10", "1.0.0-alpha.9", 1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+		{"2", "1.9.9", 1},
+		{"1.0.0+build.1", "1.0.0+build.2", -1},
+		{"latest", "0.0.1", -1},
+		{"beta", "alpha", 1},
+	}
+	for _, tc := range tests {
+		if got := compareVersionTitles(tc.a, tc.b); got != tc.want {
+			t.Errorf("compareVersionTitles(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestBumpSemver(t *testing.T) {
+	tests := []struct {
+		version, bump, want string
+	}{
+		{"1.2.3", "major", "2.0.0"},
+		{"v1.2.3", "minor", "v1.3.0"},
+		{"1.2.3", "patch", "1.2.4"},
+		{"1.4", "patch", "1.4.1"},
+		{"v2", "minor", "v2.1"},
+		{"1.4", "major", "2.0"},
+		{"2.0.0-rc.1", "major", "2.0.0"},
+		{"1.2.0-rc.1", "major", "2.0.0"},
+		{"1.3.0-beta", "minor", "1.3.0"},
+		{"1.2.3-rc.1", "patch", "1.2.3"},
+		{"1.2.3-rc.1", "prerelease", "1.2.3-rc.2"},
+		{"1.2.3-beta", "prerelease", "1.2.3-beta.1"},
+		{"1.2.3", "prerelease", "1.2.4-rc.1"},
+	}
+	for _, tc := range tests {
+		v, ok := parseSemver(tc.version)
+		if !ok {
+			t.Fatalf("parseSemver(%q) failed", tc.version)
+		}
+		got, err := bumpSemver(v, tc.bump)
+		if err != nil {
+			t.Fatalf("bumpSemver(%q, %s): %v", tc.version, tc.bump, err)
+		}
+		if got.String() != tc.want {
+			t.Errorf("bumpSemver(%q, %s) = %q, want %q", tc.version, tc.bump, got, tc.want)
+		}
+	}
+	if _, err := bumpSemver(semVersion{}, "huge"); err == nil {
+		t.Error("expected error for an unknown bump")
+	}
+}
+
+func TestSemverConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{"~2.3", []string{"2.3.0", "2.3.9", "v2.3.1-beta.1"}, []string{"2.4.0", "2.2.9", "3.0.0"}},
+		{"~2", []string{"2.0.0", "2.9.1"}, []string{"3.0.0", "1.9.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"1.2.2", "2.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"2.x", []string{"2.0.0", "2.7.1"}, []string{"3.0.0"}},
+		{"2.3", []string{"2.3.4"}, []string{"2.4.0"}},
+		{"=2.3.4", []string{"2.3.4"}, []string{"2.3.5"}},
+		{">=2.1 <3", []string{"2.1.0", "2.9.9"}, []string{"2.0.9", "3.0.0"}},
+		{"*", []string{"0.0.1", "9.9.9"}, nil},
+		{"", []string{"1.0.0"}, nil},
+	}
+	for _, tc := range tests {
+		c, err := parseSemverConstraint(tc.constraint)
+		if err != nil {
+			t.Fatalf("parseSemverConstraint(%q): %v", tc.constraint, err)
+		}
+		for _, title := range tc.match {
+			if v, _ := parseSemver(title); !c.matches(v) {
+				t.Errorf("%q should match %s", tc.constraint, title)
+			}
+		}
+		for _, title := range tc.noMatch {
+			if v, _ := parseSemver(title); c.matches(v) {
+				t.Errorf("%q should not match %s", tc.constraint, title)
+			}
+		}
+	}
+	for _, constraint := range []string{"~latest", ">=", "^x", "2.3.4.5"} {
+		if _, err := parseSemverConstraint(constraint); err == nil {
+			t.Errorf("parseSemverConstraint(%q) should fail", constraint)
+		}
+	}
+}
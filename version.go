@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -31,11 +33,25 @@ type PlatformCompatibility struct {
 	Platform string `json:"platform"`
 }
 
+// Sources holds the artifacts of a version: container images, or a single AMI for server products.
 type Sources struct {
-	Type          string                `json:"type"`
-	ID            string                `json:"id"`
-	Images        []string              `json:"images"`
-	Compatibility ServicesCompatibility `json:"compatibility"`
+	Type               string              `json:"type"`
+	ID                 string              `json:"id"`
+	Images             []string            `json:"images"`
+	Image              string              `json:"image"`
+	Architecture       string              `json:"architecture"`
+	Virtualizationtype string              `json:"virtualizationtype"`
+	Operatingsystem    Operatingsystem     `json:"operatingsystem"`
+	Accessrolearn      string              `json:"accessrolearn"`
+	Compatibility      SourceCompatibility `json:"compatibility"`
+	Platforms          []string            `json:"platforms"`
+}
+
+// SourceCompatibility is where a source runs. Delivery options built from the source inherit its services, while
+// the platform only documents the source locally since delivery options have no field for it.
+type SourceCompatibility struct {
+	Platform    string   `json:"platform"`
+	Awsservices []string `json:"awsservices"`
 }
 
 type ServicesCompatibility struct {
@@ -53,6 +69,8 @@ type Deploymentresources struct {
 
 type Recommendations struct {
 	Deploymentresources []Deploymentresources `json:"deploymentresources"`
+	Instancetype        string                `json:"instancetype"`
+	Securitygroups      []Securitygroups      `json:"securitygroups"`
 }
 
 type Deliveryoptions struct {
@@ -66,6 +84,9 @@ type Deliveryoptions struct {
 	Instructions     Instructions          `json:"instructions"`
 	Recommendations  Recommendations       `json:"recommendations"`
 	Visibility       string                `json:"visibility"`
+	Helm             Helm                  `json:"helm"`
+	Eksaddon         Eksaddon              `json:"eksaddon"`
+	Cloudformation   Cloudformation        `json:"cloudformation"`
 }
 
 // DstVersionData is the destination data structure for the AWS Marketplace API.
@@ -75,8 +96,9 @@ type DstVersionData struct {
 }
 
 type Version struct {
-	ReleaseNotes string `json:"ReleaseNotes"`
-	VersionTitle string `json:"VersionTitle"`
+	ReleaseNotes        string `json:"ReleaseNotes"`
+	VersionTitle        string `json:"VersionTitle"`
+	UpgradeInstructions string `json:"UpgradeInstructions,omitempty"`
 }
 
 type DeploymentResources struct {
@@ -92,53 +114,113 @@ type EcrDeliveryOptionDetails struct {
 	UsageInstructions   string                `json:"UsageInstructions"`
 }
 
+// Details holds the type-specific settings of a delivery option. Exactly one of them is set.
 type Details struct {
-	EcrDeliveryOptionDetails EcrDeliveryOptionDetails `json:"EcrDeliveryOptionDetails"`
+	EcrDeliveryOptionDetails                *EcrDeliveryOptionDetails                `json:"EcrDeliveryOptionDetails,omitempty"`
+	HelmDeliveryOptionDetails               *HelmDeliveryOptionDetails               `json:"HelmDeliveryOptionDetails,omitempty"`
+	EksAddOnDeliveryOptionDetails           *EksAddOnDeliveryOptionDetails           `json:"EksAddOnDeliveryOptionDetails,omitempty"`
+	AmiDeliveryOptionDetails                *AmiDeliveryOptionDetails                `json:"AmiDeliveryOptionDetails,omitempty"`
+	DeploymentTemplateDeliveryOptionDetails *DeploymentTemplateDeliveryOptionDetails `json:"DeploymentTemplateDeliveryOptionDetails,omitempty"`
 }
 
 type DeliveryOptions struct {
 	Details             Details `json:"Details"`
-	DeliveryOptionTitle string  `json:"DeliveryOptionTitle"`
+	DeliveryOptionTitle string  `json:"DeliveryOptionTitle,omitempty"`
 }
 
-// convertDeliveryOption maps a single YAML delivery option to the AWS API format.
-func convertDeliveryOption(opt Deliveryoptions, images []string) DeliveryOptions {
+func deploymentResources(opt Deliveryoptions) []DeploymentResources {
 	resources := make([]DeploymentResources, 0, len(opt.Recommendations.Deploymentresources))
 	for _, dr := range opt.Recommendations.Deploymentresources {
 		resources = append(resources, DeploymentResources{Name: dr.Text, URL: dr.URL})
 	}
-	return DeliveryOptions{
-		DeliveryOptionTitle: opt.Title,
-		Details: Details{
-			EcrDeliveryOptionDetails: EcrDeliveryOptionDetails{
-				Description:         opt.Shortdescription,
-				UsageInstructions:   opt.Instructions.Usage,
-				ContainerImages:     images,
-				CompatibleServices:  opt.Compatibility.Awsservices,
-				DeploymentResources: resources,
-			},
-		},
+	return resources
+}
+
+// compatibleServices returns the AWS services of a delivery option, falling back to those of its source.
+func compatibleServices(opt Deliveryoptions, source Sources) []string {
+	if len(opt.Compatibility.Awsservices) > 0 {
+		return opt.Compatibility.Awsservices
+	}
+	return source.Compatibility.Awsservices
+}
+
+// convertDeliveryOption maps a single YAML delivery option and the source it references to the AWS API format,
+// picking the details block that matches the option's type.
+func convertDeliveryOption(opt Deliveryoptions, source Sources) DeliveryOptions {
+	dst := DeliveryOptions{DeliveryOptionTitle: opt.Title}
+	switch {
+	case strings.EqualFold(opt.Type, deliveryOptionTypeAmi):
+		// The API does not take a title for AMI options.
+		dst.DeliveryOptionTitle = ""
+		dst.Details.AmiDeliveryOptionDetails = convertAmiDeliveryOption(opt, source)
+	case strings.EqualFold(opt.Type, deliveryOptionTypeCfn):
+		dst.Details.DeploymentTemplateDeliveryOptionDetails = convertCloudformationDeliveryOption(opt, source)
+	case strings.EqualFold(opt.Type, deliveryOptionTypeHelm):
+		dst.Details.HelmDeliveryOptionDetails = convertHelmDeliveryOption(opt, source)
+	case strings.EqualFold(opt.Type, deliveryOptionTypeEksAddOn):
+		dst.Details.EksAddOnDeliveryOptionDetails = convertEksAddOnDeliveryOption(opt, source)
+	default:
+		dst.Details.EcrDeliveryOptionDetails = &EcrDeliveryOptionDetails{
+			Description:         opt.Shortdescription,
+			UsageInstructions:   opt.Instructions.Usage,
+			ContainerImages:     source.Images,
+			CompatibleServices:  compatibleServices(opt, source),
+			DeploymentResources: deploymentResources(opt),
+		}
+	}
+	return dst
+}
+
+// sourceFor returns the source a delivery option references. Options without a source ID fall back to the first
+// source, which is all single-source versions need.
+func (src YAMLVersionData) sourceFor(opt Deliveryoptions) (Sources, error) {
+	if opt.Sourceid == "" {
+		if len(src.Sources) == 0 {
+			return Sources{}, nil
+		}
+		return src.Sources[0], nil
+	}
+	for i := range src.Sources {
+		if src.Sources[i].ID == opt.Sourceid {
+			return src.Sources[i], nil
+		}
 	}
+	return Sources{}, fmt.Errorf("delivery option %q references unknown source %q", opt.Title, opt.Sourceid)
 }
 
-func (src YAMLVersionData) convertToDst() DstVersionData {
-	var images []string
-	if len(src.Sources) > 0 {
-		images = src.Sources[0].Images
+// convertOption validates a delivery option of the version and converts it to the AWS API format.
+func (src YAMLVersionData) convertOption(opt Deliveryoptions) (DeliveryOptions, error) {
+	if err := validateDeliveryOption(opt); err != nil {
+		return DeliveryOptions{}, err
 	}
+	source, err := src.sourceFor(opt)
+	if err != nil {
+		return DeliveryOptions{}, err
+	}
+	if err := validateOptionSource(opt, source); err != nil {
+		return DeliveryOptions{}, err
+	}
+	return convertDeliveryOption(opt, source), nil
+}
 
+func (src YAMLVersionData) convertToDst() (DstVersionData, error) {
 	opts := make([]DeliveryOptions, 0, len(src.Deliveryoptions))
 	for i := range src.Deliveryoptions {
-		opts = append(opts, convertDeliveryOption(src.Deliveryoptions[i], images))
+		opt, err := src.convertOption(src.Deliveryoptions[i])
+		if err != nil {
+			return DstVersionData{}, err
+		}
+		opts = append(opts, opt)
 	}
 
 	return DstVersionData{
 		Version: Version{
-			ReleaseNotes: src.Releasenotes,
-			VersionTitle: src.Versiontitle,
+			ReleaseNotes:        src.Releasenotes,
+			VersionTitle:        src.Versiontitle,
+			UpgradeInstructions: src.Upgradeinstructions,
 		},
 		DeliveryOptions: opts,
-	}
+	}, nil
 }
 
 func getYAMLData(fileName string) (*YAMLVersionData, error) {
@@ -170,7 +252,7 @@ func dumpVersionsWithClient(svc marketplaceClient, productName string) error {
 		if err != nil {
 			return err
 		}
-		data, err := yaml.Marshal(version)
+		data, err := yaml.Marshal(details.versionDocument(version))
 		if err != nil {
 			return err
 		}
@@ -192,7 +274,7 @@ func dumpVersions(productName string) error {
 	return dumpVersionsWithClient(marketplacecatalog.NewFromConfig(cfg), productName)
 }
 
-func pushNewVersionWithClient(svc marketplaceClient, productName string, noOp bool, version string) error {
+func pushNewVersionWithClient(svc marketplaceClient, productName, version string, opts changeSetOptions) error {
 	entityID, foundType, err := findProduct(svc, productName)
 	if err != nil {
 		return err
@@ -207,59 +289,308 @@ func pushNewVersionWithClient(svc marketplaceClient, productName string, noOp bo
 		return errors.New("could not read version details: " + err.Error())
 	}
 
-	dstVersionDetails := srcVersionDetails.convertToDst()
+	if err := checkDeliveryOptionTypes(foundType, srcVersionDetails.Deliveryoptions); err != nil {
+		return err
+	}
+	dstVersionDetails, err := srcVersionDetails.convertToDst()
+	if err != nil {
+		return fmt.Errorf("could not convert version %s: %w", version, err)
+	}
+	if opts.registry.checkPlatforms {
+		if err := checkSourcePlatforms(newRegistryClient(opts.registry), srcVersionDetails.Sources); err != nil {
+			return err
+		}
+	}
+
+	return submitVersionChange(svc, productName, entityID, foundType, versionChange{
+		changeType:    "AddDeliveryOptions",
+		changeName:    "AddNewVersion",
+		changeSetName: fmt.Sprintf("Push %s version %s", productName, version),
+		details:       dstVersionDetails,
+	}, opts)
+}
+
+// versionChange is a single change to the versions of a product entity.
+type versionChange struct {
+	changeType    string
+	changeName    string
+	changeSetName string
+	details       any
+}
 
-	if noOp {
-		changeSetJSON, _ := json.MarshalIndent(dstVersionDetails, "", "  ")
+// submitVersionChange sends change as a changeset of its own, or only prints its details with --no-op.
+func submitVersionChange(svc marketplaceClient, productName, entityID, productType string, change versionChange, opts changeSetOptions) error {
+	if opts.noOp {
+		changeSetJSON, _ := json.MarshalIndent(change.details, "", "  ")
 		fmt.Println(string(changeSetJSON))
+		opts.record("", "NO-OP")
 		return nil
 	}
 
-	versionBytes, err := json.Marshal(dstVersionDetails)
+	detailsBytes, err := json.Marshal(change.details)
 	if err != nil {
 		return err
 	}
 
-	entityTypeIdentifier, _ := getEntityTypeAndChangeType(foundType)
-	versionChangeType := "AddDeliveryOptions"
-	if foundType == productTypeServer {
-		versionChangeType = "CreateVersion"
-	}
+	entityTypeIdentifier, _ := getEntityTypeAndChangeType(productType)
 
 	changeSetInput := &marketplacecatalog.StartChangeSetInput{
 		Catalog: aws.String("AWSMarketplace"),
 		ChangeSet: []types.Change{
 			{
-				ChangeType: aws.String(versionChangeType),
-				ChangeName: aws.String("AddNewVersion"),
+				ChangeType: aws.String(change.changeType),
+				ChangeName: aws.String(change.changeName),
 				Entity: &types.Entity{
 					Type:       aws.String(entityTypeIdentifier),
 					Identifier: aws.String(entityID),
 				},
-				Details: aws.String(string(versionBytes)),
+				Details: aws.String(string(detailsBytes)),
 			},
 		},
-		ChangeSetName: aws.String(fmt.Sprintf("Push %s version %s", productName, version)),
+		ChangeSetName: aws.String(change.changeSetName),
 	}
 
-	_, err = svc.StartChangeSet(context.Background(), changeSetInput)
+	token, err := changeSetRequestToken(productName, changeSetInput.ChangeSet, opts.requestToken)
 	if err != nil {
-		return errors.New("could not start change set: " + err.Error())
+		return err
 	}
+	changeSetInput.ClientRequestToken = aws.String(token)
 
-	fmt.Printf("Changeset created for product %s (%s) with entity ID %s\n", productName, foundType, entityID)
-	return nil
+	ctx, cancel := waitContext(opts)
+	defer cancel()
+	changeSetID, err := submitChangeSet(ctx, svc, changeSetInput, entityID, opts)
+	if err != nil {
+		return fmt.Errorf("could not start change set: %w", err)
+	}
+	opts.record(changeSetID, "SUBMITTED")
+
+	fmt.Printf("Changeset created for product %s (%s) with entity ID %s\n", productName, productType, entityID)
+	return awaitChangeSet(ctx, svc, changeSetID, opts)
 }
 
-func pushNewVersion(productName string, noOp bool, version string) error {
+func pushNewVersion(productName, version string, opts changeSetOptions) error {
 	cfg, err := config.LoadDefaultConfig(context.Background())
 	if err != nil {
 		return errors.New("couldn't load default config")
 	}
-	return pushNewVersionWithClient(marketplacecatalog.NewFromConfig(cfg), productName, noOp, version)
+	return pushNewVersionWithClient(marketplacecatalog.NewFromConfig(cfg), productName, version, opts)
+}
+
+// UpdatedDeliveryOption is a delivery option of a published version, identified by its ID, with its new settings.
+type UpdatedDeliveryOption struct {
+	ID string `json:"Id"`
+	DeliveryOptions
+}
+
+type UpdateDeliveryOptionsDetails struct {
+	DeliveryOptions []UpdatedDeliveryOption `json:"DeliveryOptions"`
+}
+
+// publishedVersion returns the live version with the given title.
+func publishedVersion(details *EntityDetails, version string) (*EntityVersion, error) {
+	for i := range details.Versions {
+		if details.Versions[i].VersionTitle == version {
+			return &details.Versions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("version %s is not published yet, use push-version to publish it", version)
+}
+
+// versionData converts a live version to the layout of the version YAML files, the same way dump-versions does.
+func versionData(details *EntityDetails, version *EntityVersion) (YAMLVersionData, error) {
+	var data YAMLVersionData
+	b, err := yaml.Marshal(details.versionDocument(version))
+	if err != nil {
+		return data, err
+	}
+	err = yaml.Unmarshal(b, &data)
+	return data, err
 }
 
-func cloneProductVersion(productName, srcVersion, dstVersion string) error {
+// updatableOption converts a delivery option to what UpdateDeliveryOptions accepts. The AMI of a published
+// version cannot change, so its source is left out.
+func updatableOption(opt DeliveryOptions) DeliveryOptions {
+	if ami := opt.Details.AmiDeliveryOptionDetails; ami != nil {
+		updated := *ami
+		updated.AmiSource = nil
+		opt.Details.AmiDeliveryOptionDetails = &updated
+	}
+	return opt
+}
+
+// remoteOption returns the live delivery option with the given ID in the API format.
+func remoteOption(remote YAMLVersionData, id string) (DeliveryOptions, bool) {
+	for _, opt := range remote.Deliveryoptions {
+		if opt.ID != id {
+			continue
+		}
+		// Live options are converted without validation: AWS does not return every field, such as the AMI
+		// access role, and those are not part of an update anyway.
+		source, _ := remote.sourceFor(opt)
+		return updatableOption(convertDeliveryOption(opt, source)), true
+	}
+	return DeliveryOptions{}, false
+}
+
+// sameDeliveryOption compares two delivery options by their JSON, treating null and empty lists alike: the YAML
+// files cannot tell them apart.
+func sameDeliveryOption(a, b DeliveryOptions) bool {
+	var av, bv any
+	aJSON, _ := json.Marshal(a)
+	bJSON, _ := json.Marshal(b)
+	if json.Unmarshal(aJSON, &av) != nil || json.Unmarshal(bJSON, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(dropEmptyLists(av), dropEmptyLists(bv))
+}
+
+func dropEmptyLists(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if list, ok := value.([]any); value == nil || ok && len(list) == 0 {
+				delete(v, key)
+				continue
+			}
+			v[key] = dropEmptyLists(value)
+		}
+	case []any:
+		for i := range v {
+			v[i] = dropEmptyLists(v[i])
+		}
+	}
+	return v
+}
+
+// changedDeliveryOptions returns the local delivery options whose settings differ from the live version.
+func changedDeliveryOptions(local YAMLVersionData, details *EntityDetails, remote *EntityVersion) ([]UpdatedDeliveryOption, error) {
+	live, err := versionData(details, remote)
+	if err != nil {
+		return nil, err
+	}
+	var changed []UpdatedDeliveryOption
+	for _, opt := range local.Deliveryoptions {
+		if opt.ID == "" {
+			return nil, fmt.Errorf("delivery option %q has no id, only options of the published version can be updated", opt.Title)
+		}
+		want, err := local.convertOption(opt)
+		if err != nil {
+			return nil, err
+		}
+		want = updatableOption(want)
+		have, ok := remoteOption(live, opt.ID)
+		if !ok {
+			return nil, fmt.Errorf("delivery option %s is not part of published version %s", opt.ID, remote.VersionTitle)
+		}
+		if sameDeliveryOption(want, have) {
+			continue
+		}
+		fmt.Printf("Delivery option %s (%s) has changed\n", opt.ID, opt.Title)
+		changed = append(changed, UpdatedDeliveryOption{ID: opt.ID, DeliveryOptions: want})
+	}
+	return changed, nil
+}
+
+// updateVersionWithClient sends the delivery options of a published version whose local YAML differs from the
+// live version as UpdateDeliveryOptions changes, without creating a new version.
+func updateVersionWithClient(svc marketplaceClient, productName, version string, opts changeSetOptions) error {
+	entityID, foundType, err := findProduct(svc, productName)
+	if err != nil {
+		return err
+	}
+
+	versionPath, err := getYamlFilePath(productName, "versions", version)
+	if err != nil {
+		return err
+	}
+	local, err := getYAMLData(versionPath)
+	if err != nil {
+		return errors.New("could not read version details: " + err.Error())
+	}
+	if err := checkDeliveryOptionTypes(foundType, local.Deliveryoptions); err != nil {
+		return err
+	}
+
+	details, err := describeProduct(svc, entityID)
+	if err != nil {
+		return err
+	}
+	remote, err := publishedVersion(details, version)
+	if err != nil {
+		return err
+	}
+	changed, err := changedDeliveryOptions(*local, details, remote)
+	if err != nil {
+		return fmt.Errorf("could not compare version %s: %w", version, err)
+	}
+	if len(changed) == 0 {
+		fmt.Printf("Delivery options of %s version %s have not changed\n", productName, version)
+		return nil
+	}
+
+	return submitVersionChange(svc, productName, entityID, foundType, versionChange{
+		changeType:    "UpdateDeliveryOptions",
+		changeName:    "UpdateDeliveryOptions",
+		changeSetName: fmt.Sprintf("Update %s version %s", productName, version),
+		details:       UpdateDeliveryOptionsDetails{DeliveryOptions: changed},
+	}, opts)
+}
+
+func updateVersion(productName, version string, opts changeSetOptions) error {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return errors.New("couldn't load default config")
+	}
+	return updateVersionWithClient(marketplacecatalog.NewFromConfig(cfg), productName, version, opts)
+}
+
+// retagImage replaces srcVersion with dstVersion in the tag of an image reference. The registry, repository and
+// digest-pinned references are left alone.
+func retagImage(image, srcVersion, dstVersion string) string {
+	if strings.Contains(image, "@") {
+		return image
+	}
+	colon := strings.LastIndex(image, ":")
+	if colon <= strings.LastIndex(image, "/") {
+		return image
+	}
+	return image[:colon+1] + strings.ReplaceAll(image[colon+1:], srcVersion, dstVersion)
+}
+
+// cloneVersionYAML turns the YAML of srcVersion into the YAML of dstVersion: it sets the title and drops the ID and
+// creation date AWS assigned to the source version. Image tags only move to dstVersion when retagImages is set;
+// everything else, comments included, is copied unchanged.
+func cloneVersionYAML(src []byte, srcVersion, dstVersion string, retagImages bool) ([]byte, error) {
+	doc, err := parseYAMLDocument(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.setTopLevelString("versiontitle", dstVersion); err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"id", "creationdate"} {
+		if err := doc.removeTopLevel(key); err != nil {
+			return nil, err
+		}
+	}
+	if !retagImages {
+		return doc.bytes(), nil
+	}
+	for _, list := range versionImageLists(doc) {
+		images := doc.stringItems(list)
+		for i := range images {
+			images[i] = retagImage(images[i], srcVersion, dstVersion)
+		}
+		if list.node != nil {
+			if err := doc.setStrings(list, images); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return doc.bytes(), nil
+}
+
+func cloneProductVersion(productName, srcVersion, dstVersion string, retagImages bool) error {
 	srcFilePath, err := getYamlFilePath(productName, "versions", srcVersion)
 	if err != nil {
 		return err
@@ -287,7 +618,10 @@ func cloneProductVersion(productName, srcVersion, dstVersion string) error {
 	if err != nil {
 		return fmt.Errorf("failed to read source file: %w", err)
 	}
-	output := bytes.ReplaceAll(input, []byte(srcVersion), []byte(dstVersion))
+	output, err := cloneVersionYAML(input, srcVersion, dstVersion, retagImages)
+	if err != nil {
+		return fmt.Errorf("failed to clone %s: %w", srcFilePath, err)
+	}
 	if err := os.WriteFile(dstFilePath, output, 0o644); err != nil { //nolint:gosec // G306: 0644 is intentional — user-readable YAML version files
 		return fmt.Errorf("failed to write destination file: %w", err)
 	}
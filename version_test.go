@@ -2,13 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog/types"
 	"gopkg.in/yaml.v2"
 )
 
@@ -24,7 +27,7 @@ func TestConvertDeliveryOption(t *testing.T) {
 			},
 		},
 	}
-	got := convertDeliveryOption(opt, []string{"my-ecr:latest"})
+	got := convertDeliveryOption(opt, Sources{Images: []string{"my-ecr:latest"}})
 
 	if got.DeliveryOptionTitle != "Helm Chart" {
 		t.Errorf("title = %q", got.DeliveryOptionTitle)
@@ -61,7 +64,10 @@ func TestConvertToDst(t *testing.T) {
 				{Title: "Option B"},
 			},
 		}
-		dst := src.convertToDst()
+		dst, err := src.convertToDst()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
 		if dst.Version.ReleaseNotes != "My notes" {
 			t.Errorf("release notes = %q", dst.Version.ReleaseNotes)
 		}
@@ -79,13 +85,65 @@ func TestConvertToDst(t *testing.T) {
 		}
 	})
 
+	t.Run("maps delivery options to their sources", func(t *testing.T) {
+		src := YAMLVersionData{
+			Versiontitle:        "v2.0",
+			Upgradeinstructions: "Drain the nodes first",
+			Sources: []Sources{
+				{ID: "src-linux", Images: []string{"app:linux"}, Compatibility: SourceCompatibility{Platform: "Linux"}},
+				{ID: "src-arm", Images: []string{"app:arm"}, Compatibility: SourceCompatibility{Platform: "Linux-ARM", Awsservices: []string{"EKS"}}},
+			},
+			Deliveryoptions: []Deliveryoptions{
+				{Title: "ARM", Sourceid: "src-arm"},
+				{Title: "x86", Sourceid: "src-linux", Compatibility: ServicesCompatibility{Awsservices: []string{"ECS"}}},
+			},
+		}
+		dst, err := src.convertToDst()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if dst.Version.UpgradeInstructions != "Drain the nodes first" {
+			t.Errorf("upgrade instructions = %q", dst.Version.UpgradeInstructions)
+		}
+		arm := dst.DeliveryOptions[0].Details.EcrDeliveryOptionDetails
+		if len(arm.ContainerImages) != 1 || arm.ContainerImages[0] != "app:arm" {
+			t.Errorf("ARM option = %+v", arm)
+		}
+		if len(arm.CompatibleServices) != 1 || arm.CompatibleServices[0] != "EKS" {
+			t.Errorf("ARM option should inherit the source services, got %v", arm.CompatibleServices)
+		}
+		x86 := dst.DeliveryOptions[1].Details.EcrDeliveryOptionDetails
+		if len(x86.ContainerImages) != 1 || x86.ContainerImages[0] != "app:linux" {
+			t.Errorf("x86 option = %+v", x86)
+		}
+		if len(x86.CompatibleServices) != 1 || x86.CompatibleServices[0] != "ECS" {
+			t.Errorf("x86 option services = %v", x86.CompatibleServices)
+		}
+		if b, _ := json.Marshal(dst); strings.Contains(string(b), "Platform") {
+			t.Errorf("source platforms should stay local, got %s", b)
+		}
+	})
+
+	t.Run("unknown source ID returns error", func(t *testing.T) {
+		src := YAMLVersionData{
+			Sources:         []Sources{{ID: "src-1"}},
+			Deliveryoptions: []Deliveryoptions{{Title: "Opt", Sourceid: "src-2"}},
+		}
+		if _, err := src.convertToDst(); err == nil || !strings.Contains(err.Error(), "src-2") {
+			t.Fatalf("expected unknown source error, got %v", err)
+		}
+	})
+
 	t.Run("no sources produces empty images", func(t *testing.T) {
 		src := YAMLVersionData{
 			Releasenotes:    "notes",
 			Versiontitle:    "v1.0",
 			Deliveryoptions: []Deliveryoptions{{Title: "Opt"}},
 		}
-		dst := src.convertToDst()
+		dst, err := src.convertToDst()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
 		if len(dst.DeliveryOptions) != 1 {
 			t.Fatalf("delivery options = %d", len(dst.DeliveryOptions))
 		}
@@ -135,7 +193,7 @@ func TestCloneProductVersion(t *testing.T) {
 		b, _ := yaml.Marshal(src)
 		_ = os.WriteFile(filepath.Join(dir, "v1.0.yaml"), b, 0o644)
 
-		if err := cloneProductVersion("MyProduct", "v1.0", "v2.0"); err != nil {
+		if err := cloneProductVersion("MyProduct", "v1.0", "v2.0", false); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 		dstBytes, err := os.ReadFile(filepath.Join(dir, "v2.0.yaml"))
@@ -159,7 +217,7 @@ func TestCloneProductVersion(t *testing.T) {
 		_ = os.WriteFile(filepath.Join(dir, "v1.0.yaml"), content, 0o644)
 		_ = os.WriteFile(filepath.Join(dir, "v2.0.yaml"), content, 0o644)
 
-		if err := cloneProductVersion("MyProduct", "v1.0", "v2.0"); err != nil {
+		if err := cloneProductVersion("MyProduct", "v1.0", "v2.0", false); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 	})
@@ -170,12 +228,183 @@ func TestCloneProductVersion(t *testing.T) {
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(origDir) }()
 
-		if err := cloneProductVersion("MyProduct", "nonexistent", "v2.0"); err == nil {
+		if err := cloneProductVersion("MyProduct", "nonexistent", "v2.0", false); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
+
+const cloneSourceYAML = `id: ver-1.0abc
+versiontitle: "1.0"
+# Mention 1.0 in the notes on purpose.
+releasenotes: Upgrade from 1.0 at https://example.com/1.0/notes
+creationdate: 2024-01-01T00:00:00Z
+sources:
+- type: DockerImages
+  id: src-1.0
+  images:
+  - 123.dkr.ecr.us-east-1.amazonaws.com/app-1.0:1.0 # app
+  - 123.dkr.ecr.us-east-1.amazonaws.com/agent:v1.0-alpine
+  - 123.dkr.ecr.us-east-1.amazonaws.com/pinned@sha256:1.0
+`
+
+func TestCloneVersionYAML(t *testing.T) {
+	t.Run("sets the title and drops server-assigned fields only", func(t *testing.T) {
+		got, err := cloneVersionYAML([]byte(cloneSourceYAML), "1.0", "1.1", false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := `versiontitle: "1.1"
+# Mention 1.0 in the notes on purpose.
+releasenotes: Upgrade from 1.0 at https://example.com/1.0/notes
+sources:
+- type: DockerImages
+  id: src-1.0
+  images:
+  - 123.dkr.ecr.us-east-1.amazonaws.com/app-1.0:1.0 # app
+  - 123.dkr.ecr.us-east-1.amazonaws.com/agent:v1.0-alpine
+  - 123.dkr.ecr.us-east-1.amazonaws.com/pinned@sha256:1.0
+`
+		if string(got) != want {
+			t.Errorf("got:\n%s\nwant:\n%s", got, want)
+		}
+	})
+
+	t.Run("retags image tags when asked", func(t *testing.T) {
+		got, err := cloneVersionYAML([]byte(cloneSourceYAML), "1.0", "1.1", true)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, want := range []string{
+			"- 123.dkr.ecr.us-east-1.amazonaws.com/app-1.0:1.1 # app\n",
+			"- 123.dkr.ecr.us-east-1.amazonaws.com/agent:v1.1-alpine\n",
+			"- 123.dkr.ecr.us-east-1.amazonaws.com/pinned@sha256:1.0\n",
+			"releasenotes: Upgrade from 1.0 at https://example.com/1.0/notes\n",
+		} {
+			if !strings.Contains(string(got), want) {
+				t.Errorf("clone does not contain %q:\n%s", want, got)
+			}
+		}
+	})
+
+	t.Run("invalid YAML returns error", func(t *testing.T) {
+		if _, err := cloneVersionYAML([]byte("- not a mapping\n"), "1.0", "1.1", false); err == nil {
 			t.Fatal("expected error")
 		}
 	})
 }
 
+func TestRetagImage(t *testing.T) {
+	tests := []struct {
+		image, want string
+	}{
+		{"repo:1.0", "repo:1.1"},
+		{"registry:5000/repo:v1.0-slim", "registry:5000/repo:v1.1-slim"},
+		{"registry:5000/repo-1.0", "registry:5000/repo-1.0"},
+		{"repo:latest", "repo:latest"},
+		{"repo:1.0@sha256:abc", "repo:1.0@sha256:abc"},
+	}
+	for _, tc := range tests {
+		if got := retagImage(tc.image, "1.0", "1.1"); got != tc.want {
+			t.Errorf("retagImage(%q) = %q, want %q", tc.image, got, tc.want)
+		}
+	}
+}
+
+func TestDumpVersionsKeepsUnmodeledDeliveryDetails(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	payload := `{"Versions":[{"Id":"vid-1","VersionTitle":"v1.0","ReleaseNotes":"notes","CreationDate":"2024-01-01T00:00:00Z",` +
+		`"Sources":[{"Type":"Docker","Id":"src-1","Images":["ecr/app:1.0"]}],` +
+		`"DeliveryOptions":[{"Id":"do-1","Type":"Helm","SourceId":"src-1","Title":"Helm chart",` +
+		`"HelmChartUri":"ecr/chart:1.0","QuickLaunchEnabled":true,"ChartSignature":"sig-1","OverrideParameters":[{"Key":"replicas","DefaultValue":"2"}]}]}]}`
+	svc := &mockMarketplaceClient{
+		listEntitiesFunc: func(_ context.Context, params *marketplacecatalog.ListEntitiesInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.ListEntitiesOutput, error) {
+			if *params.EntityType == productTypeContainer {
+				return makeListOutput("MyProduct", "eid-1"), nil
+			}
+			return &marketplacecatalog.ListEntitiesOutput{}, nil
+		},
+		describeEntityFunc: func(context.Context, *marketplacecatalog.DescribeEntityInput, ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeEntityOutput, error) {
+			return &marketplacecatalog.DescribeEntityOutput{Details: aws.String(payload)}, nil
+		},
+	}
+
+	if err := dumpVersionsWithClient(svc, "MyProduct"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	path := filepath.Join("data", "MyProduct", "versions", "v1.0.yaml")
+	dumped, _ := os.ReadFile(path)
+	for _, want := range []string{"versiontitle: v1.0", "sourceid: src-1", "charturi: ecr/chart:1.0", "quicklaunchenabled: true", "- key: replicas", "ChartSignature: sig-1"} {
+		if !strings.Contains(string(dumped), want) {
+			t.Errorf("dumped version does not contain %q:\n%s", want, dumped)
+		}
+	}
+	if _, err := getYAMLData(path); err != nil {
+		t.Errorf("dumped version does not parse: %v", err)
+	}
+
+	_ = os.Remove(path)
+	details, err := describeProduct(svc, "eid-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeBaseVersionYAML("MyProduct", "v1.0", details); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if base, _ := os.ReadFile(path); string(base) != string(dumped) {
+		t.Errorf("base version = %s, want the dumped version %s", base, dumped)
+	}
+}
+
+func TestDumpedHelmVersion(t *testing.T) {
+	tmpDir := t.TempDir()
+	origDir, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(origDir) }()
+
+	payload := `{"Versions":[{"Id":"vid-1","VersionTitle":"1.0.0","ReleaseNotes":"notes","CreationDate":"2024-01-01T00:00:00Z",` +
+		`"Sources":[{"Type":"DockerImages","Id":"src-1","Images":["ecr/app:1.0.0"]}],` +
+		`"DeliveryOptions":[{"Id":"do-1","Type":"Helm","SourceId":"src-1","Title":"Helm chart","ShortDescription":"Install it",` +
+		`"Instructions":{"Usage":"helm install"},"HelmChartUri":"ecr/chart:1.0.0","QuickLaunchEnabled":true,"Namespace":"app",` +
+		`"OverrideParameters":[{"Key":"replicas","DefaultValue":"2","Metadata":{"Label":"Replicas","Obfuscate":false}}]}]}]}`
+	svc := payloadMock("MyProduct", "eid-1", productTypeContainer, payload)
+	svc.startChangeSetFunc = func(context.Context, *marketplacecatalog.StartChangeSetInput, ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+		t.Error("unexpected change set")
+		return &marketplacecatalog.StartChangeSetOutput{}, nil
+	}
+	if err := dumpVersionsWithClient(svc, "MyProduct"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("update sends nothing for the dumped version", func(t *testing.T) {
+		if err := updateVersionWithClient(svc, "MyProduct", "1.0.0", changeSetOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("release clones the chart of the dumped version", func(t *testing.T) {
+		err := releaseVersionWithClient(svc, "MyProduct", "1.1.0", "ecr/app:1.1.0", "notes", "1.0.0", releaseOptions{}, changeSetOptions{noOp: true})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, err := getYAMLData(filepath.Join("data", "MyProduct", "versions", "1.1.0.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		helm := data.Deliveryoptions[0].Helm
+		if helm.Charturi == "" || !helm.Quicklaunchenabled || helm.Namespace != "app" {
+			t.Errorf("helm = %+v", helm)
+		}
+		if len(helm.Overrideparameters) != 1 || helm.Overrideparameters[0].Key != "replicas" || helm.Overrideparameters[0].Metadata.Label != "Replicas" {
+			t.Errorf("override parameters = %+v", helm.Overrideparameters)
+		}
+	})
+}
+
 func TestDumpVersionsWithClient(t *testing.T) {
 	t.Run("success writes version yaml", func(t *testing.T) {
 		tmpDir := t.TempDir()
@@ -268,7 +497,7 @@ func TestPushNewVersionWithClient(t *testing.T) {
 			Deliveryoptions: []Deliveryoptions{{Title: "Option A"}},
 		})
 		svc := &mockMarketplaceClient{listEntitiesFunc: listFoundAs(productTypeContainer)}
-		if err := pushNewVersionWithClient(svc, "MyProduct", true, "v1.0"); err != nil {
+		if err := pushNewVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{noOp: true}); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 	})
@@ -288,7 +517,7 @@ func TestPushNewVersionWithClient(t *testing.T) {
 				return &marketplacecatalog.StartChangeSetOutput{}, nil
 			},
 		}
-		if err := pushNewVersionWithClient(svc, "MyProduct", false, "v1.0"); err != nil {
+		if err := pushNewVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{}); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 		if gotChangeType != "AddDeliveryOptions" {
@@ -296,26 +525,43 @@ func TestPushNewVersionWithClient(t *testing.T) {
 		}
 	})
 
-	t.Run("uses CreateVersion for ServerProduct", func(t *testing.T) {
+	t.Run("sends AMI delivery options for ServerProduct", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		origDir, _ := os.Getwd()
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(origDir) }()
 
-		writeVersionFile(t, "v1.0", YAMLVersionData{Versiontitle: "v1.0"})
-		var gotChangeType string
+		writeVersionFile(t, "v1.0", amiVersionData("ami-0123456789abcdef0"))
+		var gotChangeType, gotDetails string
 		svc := &mockMarketplaceClient{
 			listEntitiesFunc: listFoundAs(productTypeServer),
 			startChangeSetFunc: func(_ context.Context, params *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
 				gotChangeType = *params.ChangeSet[0].ChangeType
+				gotDetails = *params.ChangeSet[0].Details
 				return &marketplacecatalog.StartChangeSetOutput{}, nil
 			},
 		}
-		if err := pushNewVersionWithClient(svc, "MyProduct", false, "v1.0"); err != nil {
+		if err := pushNewVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{}); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if gotChangeType != "CreateVersion" {
-			t.Errorf("changeType = %q, want CreateVersion", gotChangeType)
+		if gotChangeType != "AddDeliveryOptions" {
+			t.Errorf("changeType = %q, want AddDeliveryOptions", gotChangeType)
+		}
+		if !strings.Contains(gotDetails, `"AmiId":"ami-0123456789abcdef0"`) || strings.Contains(gotDetails, "EcrDeliveryOptionDetails") {
+			t.Errorf("details = %s", gotDetails)
+		}
+	})
+
+	t.Run("rejects container delivery options for ServerProduct", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		writeVersionFile(t, "v1.0", YAMLVersionData{Versiontitle: "v1.0", Deliveryoptions: []Deliveryoptions{{Title: "Images"}}})
+		svc := &mockMarketplaceClient{listEntitiesFunc: listFoundAs(productTypeServer)}
+		if err := pushNewVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{}); err == nil {
+			t.Fatal("expected error")
 		}
 	})
 
@@ -326,7 +572,7 @@ func TestPushNewVersionWithClient(t *testing.T) {
 		defer func() { _ = os.Chdir(origDir) }()
 
 		svc := &mockMarketplaceClient{listEntitiesFunc: listFoundAs(productTypeContainer)}
-		err := pushNewVersionWithClient(svc, "MyProduct", false, "nonexistent")
+		err := pushNewVersionWithClient(svc, "MyProduct", "nonexistent", changeSetOptions{})
 		if err == nil {
 			t.Fatal("expected error")
 		}
@@ -335,6 +581,66 @@ func TestPushNewVersionWithClient(t *testing.T) {
 		}
 	})
 
+	t.Run("retries send the same ClientRequestToken", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+
+		writeVersionFile(t, "v1.0", YAMLVersionData{Versiontitle: "v1.0", Releasenotes: "notes"})
+		var tokens []string
+		svc := &mockMarketplaceClient{
+			listEntitiesFunc: listFoundAs(productTypeContainer),
+			startChangeSetFunc: func(_ context.Context, params *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+				tokens = append(tokens, aws.ToString(params.ClientRequestToken))
+				return &marketplacecatalog.StartChangeSetOutput{}, nil
+			},
+		}
+		for range 2 {
+			if err := pushNewVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if tokens[0] == "" || tokens[0] != tokens[1] {
+			t.Errorf("tokens = %q, want two identical non-empty tokens", tokens)
+		}
+
+		if err := pushNewVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{requestToken: "explicit"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tokens[2] != "explicit" {
+			t.Errorf("token = %q, want explicit override", tokens[2])
+		}
+	})
+
+	t.Run("wait surfaces failed change set", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(origDir) }()
+		fastPolling(t)
+
+		writeVersionFile(t, "v1.0", YAMLVersionData{Versiontitle: "v1.0"})
+		var describedID string
+		svc := &mockMarketplaceClient{
+			listEntitiesFunc: listFoundAs(productTypeContainer),
+			startChangeSetFunc: func(_ context.Context, _ *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+				return &marketplacecatalog.StartChangeSetOutput{ChangeSetId: aws.String("cs-42")}, nil
+			},
+			describeChangeSetFunc: func(_ context.Context, params *marketplacecatalog.DescribeChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.DescribeChangeSetOutput, error) {
+				describedID = *params.ChangeSetId
+				return &marketplacecatalog.DescribeChangeSetOutput{Status: types.ChangeStatusFailed}, nil
+			},
+		}
+		err := pushNewVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{wait: true})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if describedID != "cs-42" {
+			t.Errorf("described change set = %q, want cs-42", describedID)
+		}
+	})
+
 	t.Run("StartChangeSet error returned", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		origDir, _ := os.Getwd()
@@ -348,7 +654,7 @@ func TestPushNewVersionWithClient(t *testing.T) {
 				return nil, errors.New("change set failed")
 			},
 		}
-		err := pushNewVersionWithClient(svc, "MyProduct", false, "v1.0")
+		err := pushNewVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{})
 		if err == nil {
 			t.Fatal("expected error")
 		}
@@ -358,3 +664,104 @@ func TestPushNewVersionWithClient(t *testing.T) {
 	})
 }
 
+func TestUpdateVersionWithClient(t *testing.T) {
+	const live = `{"Versions":[{"VersionTitle":"v1.0","ReleaseNotes":"notes","Id":"vid-1",
+		"Sources":[{"Type":"DockerImages","Id":"src-1","Images":["ecr/app:1.0"]}],
+		"DeliveryOptions":[
+			{"Id":"do-1","Type":"ECR","SourceId":"src-1","Title":"Images","ShortDescription":"Run it","Instructions":{"Usage":"docker run"}},
+			{"Id":"do-2","Type":"ECR","SourceId":"src-1","Title":"Other","ShortDescription":"Other","Instructions":{"Usage":"kubectl apply"}}]}]}`
+
+	// setup writes the dumped live version, changed by edit, as the local version file.
+	setup := func(t *testing.T, edit func(v *YAMLVersionData)) *mockMarketplaceClient {
+		t.Helper()
+		tmpDir := t.TempDir()
+		origDir, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		t.Cleanup(func() { _ = os.Chdir(origDir) })
+
+		var details EntityDetails
+		if err := json.Unmarshal([]byte(live), &details); err != nil {
+			t.Fatal(err)
+		}
+		local, err := versionData(&details, &details.Versions[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		edit(&local)
+		dir := filepath.Join("data", "MyProduct", "versions")
+		_ = os.MkdirAll(dir, 0o755)
+		b, _ := yaml.Marshal(local)
+		if err := os.WriteFile(filepath.Join(dir, "v1.0.yaml"), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return foundMock(t, "MyProduct", "eid-1", productTypeContainer, &details)
+	}
+
+	t.Run("unchanged version sends nothing", func(t *testing.T) {
+		svc := setup(t, func(*YAMLVersionData) {})
+		if err := updateVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("sends only the changed delivery options", func(t *testing.T) {
+		svc := setup(t, func(v *YAMLVersionData) { v.Deliveryoptions[1].Instructions.Usage = "helm install" })
+		var gotChangeType, gotDetails string
+		svc.startChangeSetFunc = func(_ context.Context, params *marketplacecatalog.StartChangeSetInput, _ ...func(*marketplacecatalog.Options)) (*marketplacecatalog.StartChangeSetOutput, error) {
+			gotChangeType = *params.ChangeSet[0].ChangeType
+			gotDetails = *params.ChangeSet[0].Details
+			return &marketplacecatalog.StartChangeSetOutput{}, nil
+		}
+		if err := updateVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if gotChangeType != "UpdateDeliveryOptions" {
+			t.Errorf("changeType = %q, want UpdateDeliveryOptions", gotChangeType)
+		}
+		var got UpdateDeliveryOptionsDetails
+		if err := json.Unmarshal([]byte(gotDetails), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.DeliveryOptions) != 1 || got.DeliveryOptions[0].ID != "do-2" ||
+			got.DeliveryOptions[0].Details.EcrDeliveryOptionDetails.UsageInstructions != "helm install" {
+			t.Errorf("details = %s", gotDetails)
+		}
+	})
+
+	t.Run("rejects options the published version does not have", func(t *testing.T) {
+		svc := setup(t, func(v *YAMLVersionData) {
+			v.Deliveryoptions = append(v.Deliveryoptions, Deliveryoptions{Title: "New", Sourceid: "src-1"})
+		})
+		err := updateVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{noOp: true})
+		if err == nil || !strings.Contains(err.Error(), "has no id") {
+			t.Errorf("error = %v", err)
+		}
+
+		svc = setup(t, func(v *YAMLVersionData) { v.Deliveryoptions[0].ID = "do-9" })
+		err = updateVersionWithClient(svc, "MyProduct", "v1.0", changeSetOptions{noOp: true})
+		if err == nil || !strings.Contains(err.Error(), "not part of published version") {
+			t.Errorf("error = %v", err)
+		}
+	})
+
+	t.Run("unpublished version returns error", func(t *testing.T) {
+		svc := setup(t, func(*YAMLVersionData) {})
+		_ = os.Rename(filepath.Join("data", "MyProduct", "versions", "v1.0.yaml"), filepath.Join("data", "MyProduct", "versions", "v2.0.yaml"))
+		err := updateVersionWithClient(svc, "MyProduct", "v2.0", changeSetOptions{noOp: true})
+		if err == nil || !strings.Contains(err.Error(), "not published yet") {
+			t.Errorf("error = %v", err)
+		}
+	})
+}
+
+func TestUpdatableOptionOmitsAmiSource(t *testing.T) {
+	data := amiVersionData("ami-0123456789abcdef0")
+	opt := convertDeliveryOption(data.Deliveryoptions[0], data.Sources[0])
+	b, _ := json.Marshal(updatableOption(opt))
+	if strings.Contains(string(b), "AmiSource") || !strings.Contains(string(b), "m5.large") {
+		t.Errorf("update payload = %s", b)
+	}
+	if opt.Details.AmiDeliveryOptionDetails.AmiSource == nil {
+		t.Error("the original option should keep its AMI source")
+	}
+}
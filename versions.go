@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/marketplacecatalog"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	versionSortOrders    = []string{"date", "semver"}
+	versionOutputFormats = []string{"table", "json", "yaml"}
+)
+
+// versionSummary is what the versions command shows about a live version.
+type versionSummary struct {
+	Title               string    `json:"title"`
+	ID                  string    `json:"id"`
+	CreationDate        time.Time `json:"creationDate"`
+	Visibility          string    `json:"visibility"`
+	DeliveryOptionTypes []string  `json:"deliveryOptionTypes"`
+	Images              []string  `json:"images"`
+}
+
+// versionVisibility is Public while buyers can launch any delivery option of the version, and otherwise the
+// visibility of its first option, such as Restricted.
+func versionVisibility(version *EntityVersion) string {
+	if isPublicVersion(version) {
+		return visibilityPublic
+	}
+	if len(version.DeliveryOptions) > 0 {
+		return version.DeliveryOptions[0].Visibility
+	}
+	return ""
+}
+
+func summarizeVersion(version *EntityVersion) versionSummary {
+	summary := versionSummary{
+		Title:               version.VersionTitle,
+		ID:                  version.ID,
+		CreationDate:        version.CreationDate,
+		Visibility:          versionVisibility(version),
+		DeliveryOptionTypes: []string{},
+		Images:              []string{},
+	}
+	for _, opt := range version.DeliveryOptions {
+		if !slices.Contains(summary.DeliveryOptionTypes, opt.Type) {
+			summary.DeliveryOptionTypes = append(summary.DeliveryOptionTypes, opt.Type)
+		}
+	}
+	for _, src := range version.Sources {
+		summary.Images = append(summary.Images, src.Images...)
+		if src.Image != "" {
+			summary.Images = append(summary.Images, src.Image)
+		}
+	}
+	return summary
+}
+
+// sortVersionSummaries puts the newest version first, by creation date or by semantic version.
+func sortVersionSummaries(versions []versionSummary, order string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if order == "semver" {
+			return compareVersionTitles(versions[i].Title, versions[j].Title) > 0
+		}
+		return versions[i].CreationDate.After(versions[j].CreationDate)
+	})
+}
+
+func printVersionTable(versions []versionSummary) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TITLE\tID\tCREATED\tVISIBILITY\tDELIVERY OPTIONS\tIMAGES")
+	for _, v := range versions {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", v.Title, v.ID, v.CreationDate.Format(time.RFC3339), v.Visibility,
+			strings.Join(v.DeliveryOptionTypes, ", "), strings.Join(v.Images, ", "))
+	}
+	return w.Flush()
+}
+
+func printVersionSummaries(versions []versionSummary, output string) error {
+	switch output {
+	case "json":
+		data, err := json.MarshalIndent(versions, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	case "yaml":
+		data, err := yaml.Marshal(versions)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+		return nil
+	}
+	return printVersionTable(versions)
+}
+
+// listVersionsWithClient prints the live versions of a product without writing any files.
+func listVersionsWithClient(svc marketplaceClient, productName, order, output string) error {
+	if !slices.Contains(versionSortOrders, order) {
+		return fmt.Errorf("invalid sort order: %s. Valid orders are: %s", order, strings.Join(versionSortOrders, ", "))
+	}
+	if !slices.Contains(versionOutputFormats, output) {
+		return fmt.Errorf("invalid output: %s. Valid outputs are: %s", output, strings.Join(versionOutputFormats, ", "))
+	}
+	entityID, _, err := findProduct(svc, productName)
+	if err != nil {
+		return err
+	}
+	details, err := describeProduct(svc, entityID)
+	if err != nil {
+		return err
+	}
+	versions := make([]versionSummary, 0, len(details.Versions))
+	for i := range details.Versions {
+		versions = append(versions, summarizeVersion(&details.Versions[i]))
+	}
+	sortVersionSummaries(versions, order)
+	return printVersionSummaries(versions, output)
+}
+
+func listVersions(productName, order, output string) error {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return err
+	}
+	return listVersionsWithClient(marketplacecatalog.NewFromConfig(cfg), productName, order, output)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const versionsLive = `{"Versions":[
+	{"VersionTitle":"1.9.0","Id":"vid-1","CreationDate":"2024-03-01T00:00:00Z",
+	 "Sources":[{"Id":"src-1","Images":["ecr/app:1.9.0","ecr/sidecar:1.9.0"]}],
+	 "DeliveryOptions":[{"Id":"do-1","Type":"ECR","Visibility":"Restricted"},{"Id":"do-2","Type":"Helm","Visibility":"Public"},{"Id":"do-3","Type":"ECR","Visibility":"Public"}]},
+	{"VersionTitle":"1.10.0","Id":"vid-2","CreationDate":"2024-02-01T00:00:00Z",
+	 "Sources":[{"Id":"src-ami","Image":"ami-0123456789abcdef0"}],
+	 "DeliveryOptions":[{"Id":"do-4","Type":"AmazonMachineImage","Visibility":"Restricted"}]}]}`
+
+func versionSummaries(t *testing.T) []versionSummary {
+	t.Helper()
+	var details EntityDetails
+	if err := json.Unmarshal([]byte(versionsLive), &details); err != nil {
+		t.Fatal(err)
+	}
+	var summaries []versionSummary
+	for i := range details.Versions {
+		summaries = append(summaries, summarizeVersion(&details.Versions[i]))
+	}
+	return summaries
+}
+
+func TestSummarizeVersion(t *testing.T) {
+	summaries := versionSummaries(t)
+	container, ami := summaries[0], summaries[1]
+	if container.Visibility != "Public" || ami.Visibility != "Restricted" {
+		t.Errorf("visibility = %q, %q", container.Visibility, ami.Visibility)
+	}
+	if strings.Join(container.DeliveryOptionTypes, ",") != "ECR,Helm" {
+		t.Errorf("delivery option types = %v", container.DeliveryOptionTypes)
+	}
+	if strings.Join(container.Images, ",") != "ecr/app:1.9.0,ecr/sidecar:1.9.0" || strings.Join(ami.Images, ",") != "ami-0123456789abcdef0" {
+		t.Errorf("images = %v, %v", container.Images, ami.Images)
+	}
+}
+
+func TestSortVersionSummaries(t *testing.T) {
+	summaries := versionSummaries(t)
+	sortVersionSummaries(summaries, "date")
+	if summaries[0].Title != "1.9.0" {
+		t.Errorf("newest by date = %q, want 1.9.0", summaries[0].Title)
+	}
+	sortVersionSummaries(summaries, "semver")
+	if summaries[0].Title != "1.10.0" {
+		t.Errorf("newest by semver = %q, want 1.10.0", summaries[0].Title)
+	}
+}
+
+func TestListVersionsWithClientValidation(t *testing.T) {
+	svc := &mockMarketplaceClient{}
+	if err := listVersionsWithClient(svc, "MyProduct", "name", "table"); err == nil || !strings.Contains(err.Error(), "invalid sort order") {
+		t.Errorf("error = %v", err)
+	}
+	if err := listVersionsWithClient(svc, "MyProduct", "date", "xml"); err == nil || !strings.Contains(err.Error(), "invalid output") {
+		t.Errorf("error = %v", err)
+	}
+	details := makeEntityDetailsWithVersion(t, "v1.0")
+	for _, output := range versionOutputFormats {
+		if err := listVersionsWithClient(foundMock(t, "MyProduct", "eid-1", productTypeContainer, details), "MyProduct", "semver", output); err != nil {
+			t.Errorf("output %s: unexpected error: %v", output, err)
+		}
+	}
+}
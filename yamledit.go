@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// yamlDocument edits values of a YAML document in place. The yaml.v3 node tree is only used to locate values:
+// each edit splices new text over the bytes of a single value, so comments, key order, quoting and indentation
+// of everything else survive byte-for-byte.
+type yamlDocument struct {
+	src      []byte
+	lines    []int // byte offset at which each line starts
+	root     *yamlv3.Node
+	edits    []yamlEdit
+	appended strings.Builder
+}
+
+type yamlEdit struct {
+	start, end int
+	text       string
+}
+
+// yamlValue is a value in the document together with what is needed to replace it: the key that owns it (nil for
+// sequence items), the column of that key or sequence dash, and the line at which the next sibling starts.
+type yamlValue struct {
+	node   *yamlv3.Node
+	key    *yamlv3.Node
+	indent int
+	limit  int
+	flow   bool
+}
+
+func parseYAMLDocument(src []byte) (*yamlDocument, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(src, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind != yamlv3.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yamlv3.MappingNode {
+		return nil, errors.New("document is not a YAML mapping")
+	}
+	d := &yamlDocument{src: src, lines: []int{0}, root: doc.Content[0]}
+	for i, b := range src {
+		if b == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	return d, nil
+}
+
+func (d *yamlDocument) top() yamlValue {
+	return yamlValue{node: d.root, indent: -1, limit: len(d.lines) + 1}
+}
+
+// line returns the text of line n (1-based) without its line ending.
+func (d *yamlDocument) line(n int) string {
+	start, end := d.lines[n-1], len(d.src)
+	if n < len(d.lines) {
+		end = d.lines[n] - 1
+	}
+	return strings.TrimSuffix(string(d.src[start:end]), "\r")
+}
+
+// offset converts a yaml.v3 line and column, which counts characters, to a byte offset.
+func (d *yamlDocument) offset(line, column int) int {
+	text := d.line(line)
+	pos := 0
+	for i := 1; i < column && pos < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[pos:])
+		pos += size
+	}
+	return d.lines[line-1] + pos
+}
+
+func indentOf(text string) int {
+	return len(text) - len(strings.TrimLeft(text, " "))
+}
+
+// field returns the value stored under key in the mapping parent.
+func (d *yamlDocument) field(parent yamlValue, key string) (yamlValue, bool) {
+	m := parent.node
+	if m == nil || m.Kind != yamlv3.MappingNode {
+		return yamlValue{}, false
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value != key {
+			continue
+		}
+		limit := parent.limit
+		if i+2 < len(m.Content) {
+			limit = m.Content[i+2].Line
+		}
+		return yamlValue{
+			node:   m.Content[i+1],
+			key:    m.Content[i],
+			indent: m.Content[i].Column - 1,
+			limit:  limit,
+			flow:   m.Style&yamlv3.FlowStyle != 0,
+		}, true
+	}
+	return yamlValue{}, false
+}
+
+// items returns the items of the sequence parent.
+func (d *yamlDocument) items(parent yamlValue) []yamlValue {
+	s := parent.node
+	if s == nil || s.Kind != yamlv3.SequenceNode {
+		return nil
+	}
+	out := make([]yamlValue, 0, len(s.Content))
+	for i, item := range s.Content {
+		limit := parent.limit
+		if i+1 < len(s.Content) {
+			limit = s.Content[i+1].Line
+		}
+		dash := strings.LastIndex(d.line(item.Line)[:d.offset(item.Line, item.Column)-d.lines[item.Line-1]], "-")
+		out = append(out, yamlValue{node: item, indent: dash, limit: limit, flow: s.Style&yamlv3.FlowStyle != 0})
+	}
+	return out
+}
+
+// stringItems returns the scalar items of the sequence v.
+func (d *yamlDocument) stringItems(v yamlValue) []string {
+	var out []string
+	for _, item := range d.items(v) {
+		out = append(out, item.node.Value)
+	}
+	return out
+}
+
+func isEmptyNode(n *yamlv3.Node) bool {
+	return n.Kind == yamlv3.ScalarNode && n.Tag == "!!null" && n.Value == ""
+}
+
+// valueLines returns the last line that belongs to v: block scalars, wrapped plain scalars and block collections
+// continue on more indented lines (sequences may also sit at the indentation of their key), while blank lines
+// and comments at the key's indentation belong to whatever follows.
+func (d *yamlDocument) valueLines(v yamlValue, first int) int {
+	last := first
+	collection := v.node.Kind != yamlv3.ScalarNode
+	for n := first + 1; n < v.limit && n <= len(d.lines); n++ {
+		text := d.line(n)
+		trimmed := strings.TrimSpace(text)
+		indent := indentOf(text)
+		switch {
+		case trimmed == "":
+			continue
+		case strings.HasPrefix(trimmed, "#") && indent <= v.indent:
+			continue
+		case indent > v.indent, collection && indent == v.indent && strings.HasPrefix(trimmed, "-"):
+			last = n
+		default:
+			return last
+		}
+	}
+	return last
+}
+
+// span returns the byte range of v. Collections and empty values are replaced from right after their key, so
+// the replacement decides whether it starts on the same line.
+func (d *yamlDocument) span(v yamlValue) (start, end int, fromKey bool) {
+	fromKey = v.key != nil && (v.node.Kind != yamlv3.ScalarNode || isEmptyNode(v.node))
+	first := v.node.Line
+	if fromKey {
+		first = v.key.Line
+		keyLine := d.line(first)
+		keyStart := d.offset(v.key.Line, v.key.Column) - d.lines[first-1]
+		start = d.lines[first-1] + keyStart + strings.Index(keyLine[keyStart:], ":") + 1
+	} else {
+		start = d.offset(v.node.Line, v.node.Column)
+	}
+	last := d.valueLines(v, first)
+	text := d.line(last)
+	cut := len(text)
+	for _, comment := range []string{v.node.LineComment, keyComment(v)} {
+		if i := strings.LastIndex(text, comment); comment != "" && i >= 0 && d.lines[last-1]+i >= start {
+			cut = min(cut, len(strings.TrimRight(text[:i], " \t")))
+		}
+	}
+	return start, max(start, d.lines[last-1]+cut), fromKey
+}
+
+func keyComment(v yamlValue) string {
+	if v.key == nil {
+		return ""
+	}
+	return v.key.LineComment
+}
+
+// encodeYAMLValue renders n the way it would follow a key at column indent.
+func encodeYAMLValue(n *yamlv3.Node, indent int) (string, error) {
+	var buf bytes.Buffer
+	enc := yamlv3.NewEncoder(&buf)
+	enc.SetIndent(2)
+	wrapper := &yamlv3.Node{Kind: yamlv3.MappingNode, Content: []*yamlv3.Node{{Kind: yamlv3.ScalarNode, Value: "x"}, n}}
+	if err := enc.Encode(wrapper); err != nil {
+		return "", err
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	lines := strings.Split(strings.TrimSuffix(strings.TrimPrefix(buf.String(), "x:"), "\n"), "\n")
+	pad := strings.Repeat(" ", max(indent, 0))
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = pad + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func stringNode(s string) *yamlv3.Node {
+	return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: s}
+}
+
+func stringsNode(values []string) *yamlv3.Node {
+	seq := &yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq"}
+	if len(values) == 0 {
+		seq.Style = yamlv3.FlowStyle
+	}
+	for _, s := range values {
+		seq.Content = append(seq.Content, stringNode(s))
+	}
+	return seq
+}
+
+// replace records an edit that swaps the bytes of v for n.
+func (d *yamlDocument) replace(v yamlValue, n *yamlv3.Node) error {
+	if v.flow {
+		return fmt.Errorf("line %d: values inside flow collections cannot be edited in place", v.node.Line)
+	}
+	text, err := encodeYAMLValue(n, v.indent)
+	if err != nil {
+		return err
+	}
+	start, end, fromKey := d.span(v)
+	if !fromKey {
+		text = strings.TrimPrefix(text, " ")
+	}
+	if strings.Contains(text, "\n") {
+		text, end = d.moveLineComment(text, end)
+	}
+	d.edits = append(d.edits, yamlEdit{start: start, end: end, text: text})
+	return nil
+}
+
+// moveLineComment moves the comment kept after end, on the last line of the replaced value, to the first line of
+// a multi-line replacement text. Left in place it would end up inside the new block scalar or sequence.
+func (d *yamlDocument) moveLineComment(text string, end int) (string, int) {
+	lineEnd := len(d.src)
+	if i := bytes.IndexByte(d.src[end:], '\n'); i >= 0 {
+		lineEnd = end + i
+	}
+	if lineEnd > end && d.src[lineEnd-1] == '\r' {
+		lineEnd--
+	}
+	comment := strings.TrimSpace(string(d.src[end:lineEnd]))
+	if comment == "" {
+		return text, end
+	}
+	first, rest, _ := strings.Cut(text, "\n")
+	return first + " " + comment + "\n" + rest, lineEnd
+}
+
+// setString sets v to s, leaving it untouched when it already holds s.
+func (d *yamlDocument) setString(v yamlValue, s string) error {
+	if v.node.Kind == yamlv3.ScalarNode && v.node.Tag == "!!str" && v.node.Value == s {
+		return nil
+	}
+	return d.replace(v, stringNode(s))
+}
+
+// setStrings sets the sequence v to values, editing items one by one when the length is unchanged.
+func (d *yamlDocument) setStrings(v yamlValue, values []string) error {
+	items := d.items(v)
+	if v.node.Kind != yamlv3.SequenceNode || v.flow || v.node.Style&yamlv3.FlowStyle != 0 || len(items) != len(values) {
+		if v.node.Kind == yamlv3.SequenceNode && len(values) == 0 && len(items) == 0 {
+			return nil
+		}
+		return d.replace(v, stringsNode(values))
+	}
+	for i, item := range items {
+		if err := d.setString(item, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setTopLevelString sets a top-level key to s, appending the key to the document when it is missing.
+func (d *yamlDocument) setTopLevelString(key, s string) error {
+	if v, ok := d.field(d.top(), key); ok {
+		return d.setString(v, s)
+	}
+	text, err := encodeYAMLValue(stringNode(s), 0)
+	if err != nil {
+		return err
+	}
+	d.appended.WriteString(key + ":" + text + "\n")
+	return nil
+}
+
+// removeTopLevel deletes a top-level key together with its value and the lines they occupy.
+func (d *yamlDocument) removeTopLevel(key string) error {
+	v, ok := d.field(d.top(), key)
+	if !ok {
+		return nil
+	}
+	if v.flow {
+		return fmt.Errorf("line %d: values inside flow collections cannot be edited in place", v.key.Line)
+	}
+	last := d.valueLines(v, v.key.Line)
+	end := len(d.src)
+	if last < len(d.lines) {
+		end = d.lines[last]
+	}
+	d.edits = append(d.edits, yamlEdit{start: d.lines[v.key.Line-1], end: end})
+	return nil
+}
+
+// bytes returns the document with every recorded edit applied.
+func (d *yamlDocument) bytes() []byte {
+	edits := append([]yamlEdit(nil), d.edits...)
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := append([]byte(nil), d.src...)
+	for _, e := range edits {
+		out = append(out[:e.start], append([]byte(e.text), out[e.end:]...)...)
+	}
+	if d.appended.Len() > 0 {
+		if len(out) > 0 && out[len(out)-1] != '\n' {
+			out = append(out, '\n')
+		}
+		out = append(out, d.appended.String()...)
+	}
+	return out
+}
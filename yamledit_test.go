@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const commentedVersionYAML = `# Maintained by the platform team, do not reorder.
+versiontitle: "1.0" # keep quoted
+releasenotes: |-
+  Old notes
+  # part of the notes
+
+  more notes
+# Images are pushed by CI.
+sources:
+- type: DockerImages
+  images:
+  - 123.dkr.ecr.us-east-1.amazonaws.com/app:1.0 # main image
+  - 123.dkr.ecr.us-east-1.amazonaws.com/sidecar:1.0
+  id: src-1
+deliveryoptions:
+- title: Helm   # aligned comment
+  shortdescription: "Install with Helm"
+upgradeinstructions:
+`
+
+func TestYAMLDocumentEdits(t *testing.T) {
+	doc, err := parseYAMLDocument([]byte(commentedVersionYAML))
+	if err != nil {
+		t.Fatalf("parseYAMLDocument() error: %v", err)
+	}
+	if err := doc.setTopLevelString("versiontitle", "1.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.setTopLevelString("releasenotes", "Line one\nLine two"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.setTopLevelString("upgradeinstructions", "none"); err != nil {
+		t.Fatal(err)
+	}
+	lists := versionImageLists(doc)
+	if len(lists) != 1 {
+		t.Fatalf("got %d image lists, want 1", len(lists))
+	}
+	if got := doc.stringItems(lists[0]); len(got) != 2 || got[1] != "123.dkr.ecr.us-east-1.amazonaws.com/sidecar:1.0" {
+		t.Fatalf("stringItems() = %v", got)
+	}
+	if err := doc.setStrings(lists[0], []string{"app:1.1", "sidecar:1.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# Maintained by the platform team, do not reorder.
+versiontitle: "1.1" # keep quoted
+releasenotes: |-
+  Line one
+  Line two
+# Images are pushed by CI.
+sources:
+- type: DockerImages
+  images:
+  - app:1.1 # main image
+  - sidecar:1.1
+  id: src-1
+deliveryoptions:
+- title: Helm   # aligned comment
+  shortdescription: "Install with Helm"
+upgradeinstructions: none
+`
+	if got := string(doc.bytes()); got != want {
+		t.Errorf("edited document:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestYAMLDocumentMovesCommentOfMultiLineValue(t *testing.T) {
+	doc, err := parseYAMLDocument([]byte("releasenotes: old # c\nsources:\n- id: src-1\n  images: [] # none yet\n"))
+	if err != nil {
+		t.Fatalf("parseYAMLDocument() error: %v", err)
+	}
+	if err := setReleaseFields(doc, "1.1", "a\nb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.setStrings(versionImageLists(doc)[0], []string{"app:1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "releasenotes: |- # c\n  a\n  b\nsources:\n- id: src-1\n  images: # none yet\n    - app:1.1\nversiontitle: \"1.1\"\n"
+	got := doc.bytes()
+	if string(got) != want {
+		t.Errorf("edited document:\n%s\nwant:\n%s", got, want)
+	}
+	reparsed, err := parseYAMLDocument(got)
+	if err != nil {
+		t.Fatalf("edited document does not parse: %v", err)
+	}
+	if notes, _ := reparsed.field(reparsed.top(), "releasenotes"); notes.node.Value != "a\nb" {
+		t.Errorf("release notes = %q, want %q", notes.node.Value, "a\nb")
+	}
+}
+
+func TestYAMLDocumentUnchangedValues(t *testing.T) {
+	doc, err := parseYAMLDocument([]byte(commentedVersionYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.setTopLevelString("releasenotes", "Old notes\n# part of the notes\n\nmore notes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.setStrings(versionImageLists(doc)[0], []string{
+		"123.dkr.ecr.us-east-1.amazonaws.com/app:1.0",
+		"123.dkr.ecr.us-east-1.amazonaws.com/sidecar:1.0",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(doc.bytes()); got != commentedVersionYAML {
+		t.Errorf("document changed although no value did:\n%s", got)
+	}
+}
+
+func TestYAMLDocumentReplacesList(t *testing.T) {
+	src := "sources:\n- images: []\n  type: DockerImages\n- type: Other\n  images:\n  - a:1\n  - b:1\n"
+	doc, err := parseYAMLDocument([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lists := versionImageLists(doc)
+	if err := doc.setStrings(lists[0], []string{"c:1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.setStrings(lists[1], []string{"d:1"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "sources:\n- images:\n    - c:1\n  type: DockerImages\n- type: Other\n  images:\n    - d:1\n"
+	if got := string(doc.bytes()); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestYAMLDocumentAppendsMissingKey(t *testing.T) {
+	doc, err := parseYAMLDocument([]byte("versiontitle: \"1.0\""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.setTopLevelString("releasenotes", "Fixes: a, b"); err != nil {
+		t.Fatal(err)
+	}
+	want := "versiontitle: \"1.0\"\nreleasenotes: 'Fixes: a, b'\n"
+	if got := string(doc.bytes()); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestYAMLDocumentErrors(t *testing.T) {
+	for _, src := range []string{"", "- a\n- b\n", "key: [unclosed\n"} {
+		if _, err := parseYAMLDocument([]byte(src)); err == nil {
+			t.Errorf("parseYAMLDocument(%q) expected error", src)
+		}
+	}
+
+	doc, err := parseYAMLDocument([]byte("{versiontitle: a, releasenotes: b}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = doc.setTopLevelString("releasenotes", "c")
+	if err == nil || !strings.Contains(err.Error(), "flow") {
+		t.Errorf("expected flow collection error, got %v", err)
+	}
+}